@@ -0,0 +1,248 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+// groupThreshold is how many tests have to fail in a package for the bot to
+// file a single issue for all of them.
+const groupThreshold = 10
+
+// analyzeMain runs the analyze command, which shows what the bot would do
+// with the reports in a directory without publishing anything. It returns the
+// exit code.
+func analyzeMain(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: flakybot analyze [flags]
+
+Analyze finds test reports like the default command does, but instead of
+publishing them, it prints the issues Flaky Bot would open or close for each
+one. Nothing is sent to Pub/Sub.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	find := &findFlags{}
+	find.register(fs)
+	st := &settings{}
+	st.register(fs)
+	asJSON := fs.Bool("json", false, "Print the analysis as JSON instead of a table.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg := &config{}
+	if err := st.load(fs, cfg); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	if err := find.apply(cfg, st); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	logs, err := findLogs(cfg)
+	if err != nil {
+		log.Printf("Error searching for logs: %v", err)
+		return exitError
+	}
+	if len(logs) == 0 {
+		logNoReports(cfg)
+		return exitError
+	}
+
+	var plans []*issuePlan
+	for _, path := range logs {
+		plans = append(plans, analyzeLog(cfg, path))
+	}
+	if *asJSON {
+		err = writeAnalysisJSON(os.Stdout, plans)
+	} else {
+		err = writeAnalysisTable(os.Stdout, cfg.logsDir, plans)
+	}
+	if err != nil {
+		log.Printf("Error writing analysis: %v", err)
+		return exitError
+	}
+	for _, p := range plans {
+		if p.Error != "" {
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+// issuePlan is what the bot would do with a single report. The bot handles
+// every report (every Pub/Sub message) separately, so plans aren't merged.
+type issuePlan struct {
+	File   string `json:"file"`
+	Passed int    `json:"passed"`
+	Failed int    `json:"failed"`
+	// Open are the issues the bot would open, or comment on or reopen if
+	// they already exist.
+	Open []*plannedIssue `json:"open"`
+	// Close are the titles of the issues the bot would close, if they're open
+	// and not flaky.
+	Close []string `json:"close"`
+	Error string   `json:"error,omitempty"`
+}
+
+// plannedIssue is an issue the bot would open.
+type plannedIssue struct {
+	Title string `json:"title"`
+	// Tests are the names of the failed tests, if this is a grouped issue.
+	Tests []string `json:"tests,omitempty"`
+}
+
+// analyzeLog reads and analyzes the report at path.
+func analyzeLog(cfg *config, path string) *issuePlan {
+	plan := &issuePlan{File: path}
+	data, err := readReport(cfg, path)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	results, err := xunit.FindTestResults(data)
+	if err != nil {
+		plan.Error = fmt.Sprintf("parsing %q: %v", path, err)
+		return plan
+	}
+	plan.Passed = len(results.Passes)
+	plan.Failed = len(results.Failures)
+	plan.Open, plan.Close = planIssues(results)
+	return plan
+}
+
+// planIssues follows the rules of openIssues and closeIssues in
+// src/flakybot.ts, assuming none of the issues are flaky, locked, or quiet.
+func planIssues(results *xunit.Results) (open []*plannedIssue, close []string) {
+	open, close = []*plannedIssue{}, []string{}
+	// Group by package to see if there are any packages with 10+ failures.
+	var pkgs []string
+	byPackage := map[string][]xunit.Test{}
+	for _, f := range results.Failures {
+		pkg := f.Package
+		if pkg == "" {
+			pkg = "all"
+		}
+		if _, ok := byPackage[pkg]; !ok {
+			pkgs = append(pkgs, pkg)
+		}
+		byPackage[pkg] = append(byPackage[pkg], f)
+	}
+	failing := map[string]bool{}
+	for _, pkg := range pkgs {
+		failures := byPackage[pkg]
+		if pkg != "all" || failures[0].Package != "" {
+			// Grouped issues for packages with failures aren't closed.
+			failing[xunit.FormatGroupedTitle(pkg)] = true
+		}
+		// closeIssues skips every title with a failure, even in packages
+		// whose failures are grouped, so a test that also passed stays open.
+		for _, f := range failures {
+			failing[xunit.FormatTestCase(f)] = true
+		}
+		if len(failures) >= groupThreshold {
+			issue := &plannedIssue{Title: xunit.FormatGroupedTitle(pkg)}
+			for _, f := range failures {
+				if f.Name != "" {
+					issue.Tests = append(issue.Tests, f.Name)
+				}
+			}
+			open = append(open, issue)
+			continue
+		}
+		for _, f := range failures {
+			open = append(open, &plannedIssue{Title: xunit.FormatTestCase(f)})
+		}
+	}
+
+	seen := map[string]bool{}
+	add := func(title string) {
+		if failing[title] || seen[title] {
+			return
+		}
+		seen[title] = true
+		close = append(close, title)
+	}
+	for _, p := range results.Passes {
+		add(xunit.FormatTestCase(p))
+	}
+	// A grouped issue is closed when there are no failures in the package
+	// and at least one pass.
+	for _, p := range results.Passes {
+		if p.Package != "" {
+			add(xunit.FormatGroupedTitle(p.Package))
+		}
+	}
+	return open, close
+}
+
+func writeAnalysisJSON(w io.Writer, plans []*issuePlan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plans)
+}
+
+func writeAnalysisTable(w io.Writer, logsDir string, plans []*issuePlan) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tPASSED\tFAILED\tACTION\tISSUE")
+	for _, p := range plans {
+		file := p.File
+		if rel, err := filepath.Rel(logsDir, p.File); err == nil {
+			file = rel
+		}
+		if p.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\terror\t%s\n", file, p.Error)
+			continue
+		}
+		first := true
+		row := func(action, issue string) {
+			if first {
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", file, p.Passed, p.Failed, action, issue)
+				first = false
+				return
+			}
+			fmt.Fprintf(tw, "\t\t\t%s\t%s\n", action, issue)
+		}
+		for _, issue := range p.Open {
+			title := issue.Title
+			if len(issue.Tests) > 0 {
+				title += " (" + strconv.Itoa(len(issue.Tests)) + " tests)"
+			}
+			row("open", title)
+		}
+		for _, title := range p.Close {
+			row("close", title)
+		}
+		if first {
+			row("-", "")
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,132 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+func TestPlanIssues(t *testing.T) {
+	var many []xunit.Test
+	var manyNames []string
+	for i := 0; i < groupThreshold; i++ {
+		name := fmt.Sprintf("TestMany%d", i)
+		many = append(many, xunit.Test{Package: "cloud.google.com/go/pubsub", Name: name})
+		manyNames = append(manyNames, name)
+	}
+
+	tests := []struct {
+		name      string
+		results   *xunit.Results
+		wantOpen  []*plannedIssue
+		wantClose []string
+	}{
+		{
+			name: "individual failures",
+			results: &xunit.Results{
+				Failures: []xunit.Test{{Package: "cloud.google.com/go/storage", Name: "TestFail"}},
+				Passes: []xunit.Test{
+					{Package: "cloud.google.com/go/storage", Name: "TestPass", Passed: true},
+					{Package: "cloud.google.com/go/storage", Name: "TestFail/subtest", Passed: true},
+					{Package: "cloud.google.com/go/spanner", Name: "TestPass", Passed: true},
+				},
+			},
+			wantOpen: []*plannedIssue{{Title: "storage: TestFail failed"}},
+			wantClose: []string{
+				"storage: TestPass failed",
+				"spanner: TestPass failed",
+				"spanner: many tests failed",
+			},
+		},
+		{
+			name: "grouped failures",
+			results: &xunit.Results{
+				Failures: append(many, xunit.Test{Package: "cloud.google.com/go/storage", Name: "TestFail"}),
+				Passes:   []xunit.Test{{Package: "cloud.google.com/go/pubsub", Name: "TestPass", Passed: true}},
+			},
+			wantOpen: []*plannedIssue{
+				{Title: "pubsub: many tests failed", Tests: manyNames},
+				{Title: "storage: TestFail failed"},
+			},
+			wantClose: []string{"pubsub: TestPass failed"},
+		},
+		{
+			// A test that failed in a grouped package isn't closed, even if
+			// it also passed.
+			name: "grouped failure that also passed",
+			results: &xunit.Results{
+				Failures: many,
+				Passes: []xunit.Test{
+					{Package: "cloud.google.com/go/pubsub", Name: "TestMany0", Passed: true},
+					{Package: "cloud.google.com/go/pubsub", Name: "TestPass", Passed: true},
+				},
+			},
+			wantOpen:  []*plannedIssue{{Title: "pubsub: many tests failed", Tests: manyNames}},
+			wantClose: []string{"pubsub: TestPass failed"},
+		},
+		{
+			name: "everything failed",
+			results: &xunit.Results{
+				Failures: []xunit.Test{{}},
+			},
+			wantOpen:  []*plannedIssue{{Title: xunit.EverythingFailedTitle}},
+			wantClose: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotOpen, gotClose := planIssues(test.results)
+			if diff := cmp.Diff(gotOpen, test.wantOpen); diff != "" {
+				t.Errorf("planIssues got open diff (-got, +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(gotClose, test.wantClose); diff != "" {
+				t.Errorf("planIssues got close diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAnalyzeLog(t *testing.T) {
+	cfg := &config{
+		logsDir: "test/fixtures/testdata",
+		formats: []*reportFormat{formatByName("junit")},
+	}
+	plans := []*issuePlan{
+		analyzeLog(cfg, "test/fixtures/testdata/node_group.xml"),
+		analyzeLog(cfg, "test/fixtures/testdata/config.yaml"),
+	}
+	if got := plans[0]; got.Error != "" || got.Failed != 24 || len(got.Open) != 1 || got.Open[0].Title != "Spanner: many tests failed" {
+		t.Errorf("analyzeLog(node_group.xml) got %+v, want 24 failures in one grouped issue", got)
+	}
+	if plans[1].Error == "" {
+		t.Errorf("analyzeLog(config.yaml) got no error, want an error")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeAnalysisTable(buf, cfg.logsDir, plans); err != nil {
+		t.Fatalf("writeAnalysisTable: %v", err)
+	}
+	for _, want := range []string{"node_group.xml", "Spanner: many tests failed (24 tests)", "config.yaml"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("writeAnalysisTable got:\n%s\nwant it to contain %q", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getenv is used to read the environment. Tests replace it.
+var getenv = os.Getenv
+
+// processStart is when flakybot started. It's the build start time on CI
+// systems that don't say when the build started.
+var processStart = time.Now()
+
+// buildInfo is what a CI system knows about the current build. Fields it
+// doesn't know are empty.
+type buildInfo struct {
+	repo     string
+	commit   string
+	buildURL string
+	branch   string
+	prNumber string
+	// headSHA and baseSHA are the head and base commits of the pull request,
+	// for presubmit builds.
+	headSHA string
+	baseSHA string
+	jobName string
+	// trigger is one of the trigger constants.
+	trigger   string
+	startTime time.Time
+	// shardIndex (starting at 0) and shardCount are set for builds split into
+	// several parallel jobs.
+	shardIndex int
+	shardCount int
+}
+
+// What started a build.
+const (
+	triggerContinuous = "continuous"
+	triggerPresubmit  = "presubmit"
+	triggerNightly    = "nightly"
+)
+
+// Modes flakybot runs in. Nightly builds run in continuous mode.
+const (
+	modeContinuous = "continuous"
+	modePresubmit  = "presubmit"
+)
+
+// setShard sets the shard of the build from CI variables. first is the index
+// of the first shard, 0 or 1 depending on the CI system.
+func (info *buildInfo) setShard(index, count string, first int) {
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 2 || i-first < 0 || i-first >= n {
+		return
+	}
+	info.shardIndex, info.shardCount = i-first, n
+}
+
+// parseTime parses an RFC 3339 timestamp, returning the zero time if it can't.
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// ciProvider is a CI system flakybot can get build information from.
+type ciProvider interface {
+	// name is the name of the CI system, for logs.
+	name() string
+	// detect reports whether the build is running on this CI system.
+	detect(getenv func(string) string) bool
+	// info returns what the CI system knows about the build.
+	info(getenv func(string) string) *buildInfo
+}
+
+// ciProviders are checked in order. Kokoro is first so builds that set
+// variables for other systems keep working like they used to.
+var ciProviders = []ciProvider{
+	kokoro{},
+	githubActions{},
+	gitLab{},
+	circleCI{},
+	buildkite{},
+	jenkins{},
+	cloudBuild{},
+}
+
+// detectCI returns the CI system the build is running on, or nil if it's not
+// running on a known one.
+func detectCI(getenv func(string) string) ciProvider {
+	for _, p := range ciProviders {
+		if p.detect(getenv) {
+			return p
+		}
+	}
+	return nil
+}
+
+type kokoro struct{}
+
+func (kokoro) name() string { return "Kokoro" }
+
+func (kokoro) detect(getenv func(string) string) bool {
+	for _, v := range []string{"KOKORO_BUILD_ID", "KOKORO_JOB_NAME", "KOKORO_GIT_COMMIT", "KOKORO_GITHUB_COMMIT_URL", "KOKORO_GITHUB_PULL_REQUEST_URL"} {
+		if getenv(v) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (kokoro) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		commit:   getenv("KOKORO_GIT_COMMIT"),
+		prNumber: getenv("KOKORO_GITHUB_PULL_REQUEST_NUMBER"),
+	}
+	githubURL := getenv("KOKORO_GITHUB_COMMIT_URL")
+	if githubURL == "" {
+		githubURL = getenv("KOKORO_GITHUB_PULL_REQUEST_URL")
+		if githubURL != "" && info.prNumber == "" {
+			info.prNumber = path.Base(githubURL)
+		}
+	}
+	info.repo = repoFromURL(githubURL)
+	info.jobName = getenv("KOKORO_JOB_NAME")
+	if info.prNumber != "" {
+		info.headSHA = firstNonEmpty(getenv("KOKORO_GITHUB_PULL_REQUEST_COMMIT"), info.commit)
+	}
+	switch {
+	case info.prNumber != "":
+		info.trigger = triggerPresubmit
+	case strings.Contains(info.jobName, "nightly") || strings.Contains(info.jobName, "periodic"):
+		info.trigger = triggerNightly
+	case getenv("KOKORO_GITHUB_COMMIT_URL") != "":
+		info.trigger = triggerContinuous
+	}
+	if buildID := getenv("KOKORO_BUILD_ID"); buildID != "" {
+		info.buildURL = fmt.Sprintf("[Build Status](https://source.cloud.google.com/results/invocations/%s), [Sponge](http://sponge2/%s)", buildID, buildID)
+	}
+	return info
+}
+
+type githubActions struct{}
+
+func (githubActions) name() string { return "GitHub Actions" }
+
+func (githubActions) detect(getenv func(string) string) bool {
+	return getenv("GITHUB_ACTIONS") == "true"
+}
+
+func (githubActions) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		repo:   getenv("GITHUB_REPOSITORY"),
+		commit: getenv("GITHUB_SHA"),
+		// GITHUB_HEAD_REF is only set for pull requests.
+		branch: getenv("GITHUB_HEAD_REF"),
+	}
+	if info.branch == "" && getenv("GITHUB_REF_TYPE") == "branch" {
+		info.branch = getenv("GITHUB_REF_NAME")
+	}
+	// Pull request builds run on refs/pull/<number>/merge.
+	if ref := strings.Split(getenv("GITHUB_REF"), "/"); len(ref) == 4 && ref[1] == "pull" {
+		info.prNumber = ref[2]
+	}
+	server := getenv("GITHUB_SERVER_URL")
+	if server == "" {
+		server = "https://github.com"
+	}
+	if runID := getenv("GITHUB_RUN_ID"); runID != "" && info.repo != "" {
+		info.buildURL = fmt.Sprintf("%s/%s/actions/runs/%s", server, info.repo, runID)
+	}
+	info.jobName = getenv("GITHUB_WORKFLOW")
+	if job := getenv("GITHUB_JOB"); job != "" && info.jobName != "" {
+		info.jobName += " / " + job
+	}
+	switch getenv("GITHUB_EVENT_NAME") {
+	case "pull_request", "pull_request_target", "merge_group":
+		info.trigger = triggerPresubmit
+		info.headSHA, info.baseSHA = githubEventSHAs(getenv("GITHUB_EVENT_PATH"))
+	case "schedule":
+		info.trigger = triggerNightly
+	case "push":
+		info.trigger = triggerContinuous
+	}
+	return info
+}
+
+// githubEventSHAs returns the head and base commits of the pull request in a
+// GitHub Actions event payload. GITHUB_SHA is the merge commit for pull
+// requests, which isn't either one.
+func githubEventSHAs(path string) (head, base string) {
+	if path == "" {
+		return "", ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Unable to read GITHUB_EVENT_PATH: %v", err)
+		return "", ""
+	}
+	var event struct {
+		PullRequest struct {
+			Head struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+			Base struct {
+				SHA string `json:"sha"`
+			} `json:"base"`
+		} `json:"pull_request"`
+		MergeGroup struct {
+			HeadSHA string `json:"head_sha"`
+			BaseSHA string `json:"base_sha"`
+		} `json:"merge_group"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("Unable to parse GITHUB_EVENT_PATH: %v", err)
+		return "", ""
+	}
+	return firstNonEmpty(event.PullRequest.Head.SHA, event.MergeGroup.HeadSHA), firstNonEmpty(event.PullRequest.Base.SHA, event.MergeGroup.BaseSHA)
+}
+
+type gitLab struct{}
+
+func (gitLab) name() string { return "GitLab CI" }
+
+func (gitLab) detect(getenv func(string) string) bool {
+	return getenv("GITLAB_CI") == "true"
+}
+
+func (gitLab) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		repo:     getenv("CI_PROJECT_PATH"),
+		commit:   getenv("CI_COMMIT_SHA"),
+		buildURL: getenv("CI_JOB_URL"),
+		branch:   firstNonEmpty(getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"), getenv("CI_EXTERNAL_PULL_REQUEST_SOURCE_BRANCH_NAME"), getenv("CI_COMMIT_BRANCH")),
+		// External pull requests are GitHub pull requests of repos mirrored to
+		// GitLab.
+		prNumber:  firstNonEmpty(getenv("CI_EXTERNAL_PULL_REQUEST_IID"), getenv("CI_MERGE_REQUEST_IID")),
+		headSHA:   firstNonEmpty(getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_SHA"), getenv("CI_EXTERNAL_PULL_REQUEST_SOURCE_BRANCH_SHA")),
+		baseSHA:   firstNonEmpty(getenv("CI_MERGE_REQUEST_TARGET_BRANCH_SHA"), getenv("CI_MERGE_REQUEST_DIFF_BASE_SHA"), getenv("CI_EXTERNAL_PULL_REQUEST_TARGET_BRANCH_SHA")),
+		jobName:   getenv("CI_JOB_NAME"),
+		startTime: parseTime(firstNonEmpty(getenv("CI_PIPELINE_CREATED_AT"), getenv("CI_JOB_STARTED_AT"))),
+	}
+	switch getenv("CI_PIPELINE_SOURCE") {
+	case "merge_request_event", "external_pull_request_event":
+		info.trigger = triggerPresubmit
+	case "schedule":
+		info.trigger = triggerNightly
+	case "push":
+		info.trigger = triggerContinuous
+	}
+	// CI_NODE_INDEX starts at 1.
+	info.setShard(getenv("CI_NODE_INDEX"), getenv("CI_NODE_TOTAL"), 1)
+	return info
+}
+
+type circleCI struct{}
+
+func (circleCI) name() string { return "CircleCI" }
+
+func (circleCI) detect(getenv func(string) string) bool {
+	return getenv("CIRCLECI") == "true"
+}
+
+func (circleCI) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		repo:     repoFromURL(getenv("CIRCLE_REPOSITORY_URL")),
+		commit:   getenv("CIRCLE_SHA1"),
+		buildURL: getenv("CIRCLE_BUILD_URL"),
+		branch:   getenv("CIRCLE_BRANCH"),
+		prNumber: getenv("CIRCLE_PR_NUMBER"),
+	}
+	if owner, name := getenv("CIRCLE_PROJECT_USERNAME"), getenv("CIRCLE_PROJECT_REPONAME"); owner != "" && name != "" {
+		info.repo = owner + "/" + name
+	}
+	if pr := getenv("CIRCLE_PULL_REQUEST"); info.prNumber == "" && pr != "" {
+		info.prNumber = path.Base(pr)
+	}
+	info.jobName = getenv("CIRCLE_JOB")
+	if info.prNumber != "" {
+		info.trigger = triggerPresubmit
+		info.headSHA = info.commit
+	} else if info.branch != "" {
+		info.trigger = triggerContinuous
+	}
+	info.setShard(getenv("CIRCLE_NODE_INDEX"), getenv("CIRCLE_NODE_TOTAL"), 0)
+	return info
+}
+
+type buildkite struct{}
+
+func (buildkite) name() string { return "Buildkite" }
+
+func (buildkite) detect(getenv func(string) string) bool {
+	return getenv("BUILDKITE") == "true"
+}
+
+func (buildkite) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		repo:     repoFromURL(getenv("BUILDKITE_REPO")),
+		commit:   getenv("BUILDKITE_COMMIT"),
+		buildURL: getenv("BUILDKITE_BUILD_URL"),
+		branch:   getenv("BUILDKITE_BRANCH"),
+	}
+	// BUILDKITE_PULL_REQUEST is "false" for builds that aren't pull requests.
+	if pr := getenv("BUILDKITE_PULL_REQUEST"); pr != "false" {
+		info.prNumber = pr
+	}
+	info.jobName = firstNonEmpty(getenv("BUILDKITE_LABEL"), getenv("BUILDKITE_PIPELINE_SLUG"))
+	switch {
+	case info.prNumber != "":
+		info.trigger = triggerPresubmit
+		info.headSHA = info.commit
+	case getenv("BUILDKITE_SOURCE") == "schedule":
+		info.trigger = triggerNightly
+	case getenv("BUILDKITE_SOURCE") != "":
+		info.trigger = triggerContinuous
+	}
+	info.setShard(getenv("BUILDKITE_PARALLEL_JOB"), getenv("BUILDKITE_PARALLEL_JOB_COUNT"), 0)
+	return info
+}
+
+type jenkins struct{}
+
+func (jenkins) name() string { return "Jenkins" }
+
+func (jenkins) detect(getenv func(string) string) bool {
+	return getenv("JENKINS_URL") != ""
+}
+
+func (jenkins) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		repo:     repoFromURL(getenv("GIT_URL")),
+		commit:   getenv("GIT_COMMIT"),
+		buildURL: getenv("BUILD_URL"),
+		// CHANGE_* are set by multibranch pipelines for pull requests.
+		branch:   firstNonEmpty(getenv("CHANGE_BRANCH"), getenv("BRANCH_NAME"), strings.TrimPrefix(getenv("GIT_BRANCH"), "origin/")),
+		prNumber: getenv("CHANGE_ID"),
+		jobName:  getenv("JOB_NAME"),
+	}
+	if info.prNumber != "" {
+		info.trigger = triggerPresubmit
+	} else if info.branch != "" {
+		info.trigger = triggerContinuous
+	}
+	return info
+}
+
+// cloudBuild only sets BUILD_ID, PROJECT_ID, and LOCATION in the environment.
+// Pass the other values in the build step's env, for example
+// COMMIT_SHA=$COMMIT_SHA.
+type cloudBuild struct{}
+
+func (cloudBuild) name() string { return "Cloud Build" }
+
+func (cloudBuild) detect(getenv func(string) string) bool {
+	return getenv("BUILD_ID") != "" && getenv("PROJECT_ID") != ""
+}
+
+func (cloudBuild) info(getenv func(string) string) *buildInfo {
+	info := &buildInfo{
+		repo:     getenv("REPO_FULL_NAME"),
+		commit:   getenv("COMMIT_SHA"),
+		branch:   firstNonEmpty(getenv("_HEAD_BRANCH"), getenv("BRANCH_NAME")),
+		prNumber: getenv("_PR_NUMBER"),
+	}
+	info.jobName = getenv("TRIGGER_NAME")
+	if info.prNumber != "" {
+		info.trigger = triggerPresubmit
+		info.headSHA = info.commit
+	} else if info.branch != "" {
+		info.trigger = triggerContinuous
+	}
+	buildID, project := getenv("BUILD_ID"), getenv("PROJECT_ID")
+	if loc := getenv("LOCATION"); loc != "" && loc != "global" {
+		info.buildURL = fmt.Sprintf("https://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s", loc, buildID, project)
+	} else {
+		info.buildURL = fmt.Sprintf("https://console.cloud.google.com/cloud-build/builds/%s?project=%s", buildID, project)
+	}
+	return info
+}
+
+// repoFromURL returns the owner/repo of a repo or commit URL, like
+// https://github.com/owner/repo/commit/abc, git@github.com:owner/repo.git, or
+// ssh://git@github.com/owner/repo.git. It returns "" if there is no repo.
+func repoFromURL(u string) string {
+	var p string
+	if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+		p = parsed.Path
+	} else if _, rest, ok := strings.Cut(u, ":"); ok && strings.Contains(u[:len(u)-len(rest)], "@") {
+		// scp-like syntax: user@host:owner/repo.git.
+		p = rest
+	} else {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,390 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the name of the optional config file, looked for in
+// --logs_dir and then the root of the git checkout.
+const configFileName = ".flakybot.yaml"
+
+// Where a setting can come from, besides a CI system or a config file path.
+const (
+	sourceFlag        = "flag"
+	sourceDefault     = "default"
+	sourceGit         = "git checkout"
+	sourceGitHubAPI   = "GitHub API"
+	sourceKnownOrgs   = "known installations"
+	sourceBranchTopic = "branch_topics"
+)
+
+// fileConfig is the contents of a .flakybot.yaml file. Keys are the names of
+// the matching flags.
+type fileConfig struct {
+	Repo             string   `yaml:"repo"`
+	InstallationID   string   `yaml:"installation_id"`
+	Project          string   `yaml:"project"`
+	Topic            string   `yaml:"topic"`
+	PresubmitTopic   string   `yaml:"presubmit_topic"`
+	LogsDir          string   `yaml:"logs_dir"`
+	Formats          []string `yaml:"formats"`
+	Include          []string `yaml:"include"`
+	Exclude          []string `yaml:"exclude"`
+	Transport        string   `yaml:"transport"`
+	HTTPURL          string   `yaml:"http_url"`
+	PresubmitHTTPURL string   `yaml:"presubmit_http_url"`
+	OnEmpty          string   `yaml:"on_empty"`
+	OnPublishError   string   `yaml:"on_publish_error"`
+	// BranchTopics maps branch names or patterns, like release-*, to the topic
+	// to publish to for builds of those branches.
+	BranchTopics map[string]string `yaml:"branch_topics"`
+	// Redact are replaced in reports before they're published.
+	Redact []redactRule `yaml:"redact"`
+}
+
+// redactRule replaces the matches of a regular expression in reports.
+type redactRule struct {
+	Pattern string `yaml:"pattern"`
+	// Replacement can refer to submatches, like ${1}. Defaults to
+	// [REDACTED].
+	Replacement string `yaml:"replacement"`
+}
+
+// redaction is a compiled redactRule.
+type redaction struct {
+	re          *regexp.Regexp
+	replacement []byte
+}
+
+// parseConfigFile parses a .flakybot.yaml file. It also returns the keys it
+// doesn't know about, so they can be reported instead of silently ignored.
+func parseConfigFile(data []byte) (fc *fileConfig, unknown []string, err error) {
+	fc = &fileConfig{}
+	if err := yaml.Unmarshal(data, fc); err != nil {
+		return nil, nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+	known := map[string]bool{}
+	t := reflect.TypeOf(fileConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		known[t.Field(i).Tag.Get("yaml")] = true
+	}
+	for k := range raw {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return fc, unknown, nil
+}
+
+// redactions compiles the redaction rules.
+func (fc *fileConfig) redactions() ([]*redaction, error) {
+	var rs []*redaction
+	for _, r := range fc.Redact {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", r.Pattern, err)
+		}
+		replacement := r.Replacement
+		if replacement == "" {
+			replacement = "[REDACTED]"
+		}
+		rs = append(rs, &redaction{re: re, replacement: []byte(replacement)})
+	}
+	return rs, nil
+}
+
+// branchTopic returns the topic for branch, preferring an exact match over a
+// pattern. Longer (more specific) patterns are tried first.
+func (fc *fileConfig) branchTopic(branch string) string {
+	if branch == "" {
+		return ""
+	}
+	if topic, ok := fc.BranchTopics[branch]; ok {
+		return topic
+	}
+	var patterns []string
+	for p := range fc.BranchTopics {
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, branch); ok {
+			return fc.BranchTopics[p]
+		}
+	}
+	return ""
+}
+
+// settings layers the flags, FLAKYBOT_ environment variables, and
+// .flakybot.yaml file, and records where each value came from. Flags take
+// precedence over the environment, which takes precedence over the file.
+type settings struct {
+	configPath string
+	verbose    bool
+
+	set map[string]bool
+	// file is the loaded config file, or an empty one if there isn't one.
+	file     *fileConfig
+	filePath string
+	sources  map[string]string
+}
+
+func (s *settings) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.configPath, "config", "", "Path to a "+configFileName+" file. Defaults to the one in --logs_dir or the root of the git checkout, if there is one.")
+	fs.BoolVar(&s.verbose, "v", false, "Log the effective config, and where each value came from.")
+}
+
+// load records which flags were set, loads the config file, and sets the
+// settings that only come from the file in cfg. It must be called after fs is
+// parsed.
+func (s *settings) load(fs *flag.FlagSet, cfg *config) error {
+	s.set = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { s.set[f.Name] = true })
+	s.sources = map[string]string{}
+	s.file = &fileConfig{}
+	cfg.sources = s.sources
+	cfg.file = s.file
+
+	p := s.configPath
+	if p == "" {
+		p = findConfigFile(s.candidateDirs(fs))
+		if p == "" {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	fc, unknown, err := parseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %v", p, err)
+	}
+	for _, k := range unknown {
+		log.Printf("Unknown key %q in %s.", k, p)
+	}
+	rs, err := fc.redactions()
+	if err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	log.Printf("Using config file %s.", p)
+	s.file, s.filePath = fc, p
+	cfg.file, cfg.configFile, cfg.redactions = fc, p, rs
+	return nil
+}
+
+// candidateDirs returns the directories to look for a config file in: the
+// logs dir, if there is one, then the root of the git checkout.
+func (s *settings) candidateDirs(fs *flag.FlagSet) []string {
+	var dirs []string
+	if f := fs.Lookup("logs_dir"); f != nil {
+		dir := f.Value.String()
+		if v := getenv(envName("logs_dir")); v != "" && !s.set["logs_dir"] {
+			dir = v
+		}
+		dirs = append(dirs, dir)
+	}
+	if r, err := findGitRepo(gitStartDir); err == nil {
+		dirs = append(dirs, r.root)
+	}
+	return dirs
+}
+
+// findConfigFile returns the path of the first config file in dirs, or "".
+func findConfigFile(dirs []string) string {
+	for _, dir := range dirs {
+		p := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Skipping %s: %v", p, err)
+		}
+	}
+	return ""
+}
+
+// envName returns the environment variable for a setting, like FLAKYBOT_REPO
+// for repo.
+func envName(name string) string {
+	return "FLAKYBOT_" + strings.ToUpper(name)
+}
+
+// string returns the value of a setting from the flag, if it was set, then
+// the environment, then the config file, then flagValue (the flag's default).
+func (s *settings) string(name, flagValue, fileValue string) string {
+	switch {
+	case s.set[name]:
+		s.sources[name] = sourceFlag
+		return flagValue
+	case getenv(envName(name)) != "":
+		s.sources[name] = "$" + envName(name)
+		return getenv(envName(name))
+	case fileValue != "":
+		s.sources[name] = s.filePath
+		return fileValue
+	}
+	s.sources[name] = sourceDefault
+	return flagValue
+}
+
+// strings is like string, for list settings. Environment variables are comma
+// separated.
+func (s *settings) strings(name string, flagValue, fileValue []string) []string {
+	switch {
+	case s.set[name]:
+		s.sources[name] = sourceFlag
+		return flagValue
+	case getenv(envName(name)) != "":
+		s.sources[name] = "$" + envName(name)
+		return strings.Split(getenv(envName(name)), ",")
+	case len(fileValue) > 0:
+		s.sources[name] = s.filePath
+		return fileValue
+	}
+	s.sources[name] = sourceDefault
+	return flagValue
+}
+
+// flagOnly returns the value of a setting that can only be set with a flag,
+// and records if it was.
+func (s *settings) flagOnly(name, flagValue string) string {
+	if s.set[name] {
+		s.sources[name] = sourceFlag
+	}
+	return flagValue
+}
+
+// sourced is a detected value and where it came from.
+type sourced struct {
+	value, source string
+}
+
+// fill sets *field to the first of values that isn't empty, and records its
+// source, unless *field is already set.
+func (cfg *config) fill(name string, field *string, values ...sourced) {
+	if *field != "" {
+		return
+	}
+	for _, v := range values {
+		if v.value != "" {
+			*field = v.value
+			cfg.setSource(name, v.source)
+			return
+		}
+	}
+}
+
+func (cfg *config) setSource(name, source string) {
+	if cfg.sources == nil {
+		cfg.sources = map[string]string{}
+	}
+	cfg.sources[name] = source
+}
+
+// configRow is a setting, its effective value, and where it came from.
+type configRow struct {
+	name, value, source string
+}
+
+// configRows returns the effective settings, in the order they're shown.
+func (cfg *config) configRows() []configRow {
+	shard := ""
+	if cfg.shardCount > 0 {
+		shard = fmt.Sprintf("%d/%d", cfg.shardIndex, cfg.shardCount)
+	}
+	rows := []configRow{
+		{name: "repo", value: cfg.repo},
+		{name: "installation_id", value: cfg.installationID},
+		{name: "commit_hash", value: cfg.commit},
+		{name: "build_url", value: cfg.buildURL},
+		{name: "branch", value: cfg.branch},
+		{name: "pr_number", value: cfg.prNumber},
+		{name: "head_sha", value: cfg.headSHA},
+		{name: "base_sha", value: cfg.baseSHA},
+		{name: "job_name", value: cfg.jobName},
+		{name: "trigger", value: cfg.trigger},
+		{name: "mode", value: cfg.mode},
+		{name: "build_start_time", value: formatTime(cfg.buildStart)},
+		{name: "shard", value: shard},
+		{name: "project", value: cfg.projectID},
+		{name: "topic", value: cfg.topicID},
+		{name: "presubmit_topic", value: cfg.presubmitTopic},
+		{name: "service_account", value: cfg.serviceAccount},
+		{name: "transport", value: cfg.transport},
+		{name: "http_url", value: cfg.httpURL},
+		{name: "presubmit_http_url", value: cfg.presubmitHTTPURL},
+		{name: "logs_dir", value: cfg.logsDir},
+		{name: "formats", value: strings.Join(formatNames(cfg.formats), ",")},
+		{name: "include", value: strings.Join(cfg.includes, ",")},
+		{name: "exclude", value: strings.Join(cfg.excludes, ",")},
+	}
+	for i := range rows {
+		rows[i].source = cfg.sources[rows[i].name]
+	}
+	// The credentials are only for Pub/Sub.
+	if cfg.transport == transportPubSub || cfg.transport == "" {
+		rows = append(rows, cfg.credentialRow())
+	}
+	if len(cfg.redactions) > 0 {
+		rows = append(rows, configRow{name: "redact", value: fmt.Sprintf("%d rules", len(cfg.redactions)), source: cfg.configFile})
+	}
+	return rows
+}
+
+// logConfig logs the effective config, for -v.
+func logConfig(cfg *config) {
+	for _, r := range cfg.configRows() {
+		if r.value == "" {
+			continue
+		}
+		if r.source == "" {
+			log.Printf("%s=%s", r.name, r.value)
+			continue
+		}
+		log.Printf("%s=%s (from %s)", r.name, r.value, r.source)
+	}
+}
+
+// redact applies the redaction rules to a report.
+func redact(data []byte, rs []*redaction) []byte {
+	for _, r := range rs {
+		data = r.re.ReplaceAll(data, r.replacement)
+	}
+	return data
+}
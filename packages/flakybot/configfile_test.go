@@ -0,0 +1,300 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	fc, unknown, err := parseConfigFile([]byte(`
+repo: my-org/my-repo
+installation_id: 123
+include: ["**/TEST-*.xml"]
+redact:
+  - pattern: token=\w+
+colour: blue
+branch_topics:
+  main: main-topic
+`))
+	if err != nil {
+		t.Fatalf("parseConfigFile: %v", err)
+	}
+	want := &fileConfig{
+		Repo:           "my-org/my-repo",
+		InstallationID: "123",
+		Include:        []string{"**/TEST-*.xml"},
+		Redact:         []redactRule{{Pattern: `token=\w+`}},
+		BranchTopics:   map[string]string{"main": "main-topic"},
+	}
+	if diff := cmp.Diff(fc, want); diff != "" {
+		t.Errorf("parseConfigFile got diff (-got, +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(unknown, []string{"colour"}); diff != "" {
+		t.Errorf("parseConfigFile unknown keys diff (-got, +want):\n%s", diff)
+	}
+
+	if _, _, err := parseConfigFile([]byte("include: not-a-list")); err == nil {
+		t.Errorf("parseConfigFile(include: not-a-list) got nil error, want an error")
+	}
+	bad := &fileConfig{Redact: []redactRule{{Pattern: "("}}}
+	if _, err := bad.redactions(); err == nil {
+		t.Errorf("redactions got nil error for an invalid pattern, want an error")
+	}
+}
+
+func TestBranchTopic(t *testing.T) {
+	fc := &fileConfig{BranchTopics: map[string]string{
+		"main":      "main-topic",
+		"release-*": "release-topic",
+		"*":         "other-topic",
+		"release-1": "release-1-topic",
+	}}
+	tests := map[string]string{
+		"main":      "main-topic",
+		"release-1": "release-1-topic",
+		"release-2": "release-topic",
+		"feature":   "other-topic",
+		"":          "",
+	}
+	for branch, want := range tests {
+		if got := fc.branchTopic(branch); got != want {
+			t.Errorf("branchTopic(%q) = %q, want %q", branch, got, want)
+		}
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".git/HEAD":                 "ref: refs/heads/release-2\n",
+		".git/config":               "[remote \"origin\"]\n\turl = https://github.com/googleapis/repo-automation-bots.git\n",
+		".git/refs/heads/release-2": sha1 + "\n",
+		configFileName: `
+repo: my-org/ignored
+installation_id: 999
+project: file-project
+topic: file-topic
+logs_dir: build/results
+include: ["**/TEST-*.xml"]
+branch_topics:
+  release-*: release-topic
+`,
+	})
+	getenv = fakeEnv{"FLAKYBOT_EXCLUDE": "vendor,third_party"}.get
+	defer func() { getenv = os.Getenv }()
+	gitStartDir = root
+	defer func() { gitStartDir = "." }()
+
+	cfg := loadConfig(t, "-project=flag-project")
+	path := filepath.Join(root, configFileName)
+	got := map[string]string{}
+	for _, r := range cfg.configRows() {
+		if r.value != "" {
+			got[r.name] = r.value + " from " + r.source
+		}
+	}
+	want := map[string]string{
+		// The local git checkout is only used if the file doesn't say.
+		"repo":            "my-org/ignored from " + path,
+		"installation_id": "999 from " + path,
+		"commit_hash":     sha1 + " from " + sourceGit,
+		"branch":          "release-2 from " + sourceGit,
+		"mode":            modeContinuous + " from " + sourceDefault,
+		// The local git checkout doesn't say when the build started.
+		"build_start_time": formatTime(processStart) + " from " + sourceDefault,
+		"project":          "flag-project from " + sourceFlag,
+		"topic":            "release-topic from " + path + " (branch_topics)",
+		"logs_dir":         filepath.Join(root, "build/results") + " from " + path,
+		"formats":          "sponge from " + sourceDefault,
+		"include":          "**/TEST-*.xml from " + path,
+		"exclude":          "vendor,third_party from $FLAKYBOT_EXCLUDE",
+		"credentials":      "application default credentials from " + sourceDefault,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("config diff (-got, +want):\n%s", diff)
+	}
+
+	// A topic flag beats the branch topic.
+	cfg = loadConfig(t, "-topic=flag-topic", "-repo=googleapis/repo-automation-bots")
+	if cfg.topicID != "flag-topic" || cfg.repo != "googleapis/repo-automation-bots" {
+		t.Errorf("got topic %q and repo %q, want the flags", cfg.topicID, cfg.repo)
+	}
+
+	// --config overrides the search.
+	other := filepath.Join(t.TempDir(), "other.yaml")
+	if err := os.WriteFile(other, []byte("topic: other-topic\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	cfg = loadConfig(t, "-config", other)
+	if cfg.topicID != "other-topic" || cfg.sources["topic"] != other {
+		t.Errorf("with --config got topic %q from %q, want other-topic from %s", cfg.topicID, cfg.sources["topic"], other)
+	}
+}
+
+// loadConfig loads the config like the env command, with the given flags.
+func loadConfig(t *testing.T, args ...string) *config {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf := &configFlags{}
+	cf.register(fs)
+	find := &findFlags{}
+	find.register(fs)
+	st := &settings{}
+	st.register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	cfg := &config{}
+	if err := st.load(fs, cfg); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := cf.apply(cfg, st); err != nil {
+		t.Fatalf("configFlags.apply: %v", err)
+	}
+	if err := find.apply(cfg, st); err != nil {
+		t.Fatalf("findFlags.apply: %v", err)
+	}
+	cfg.detect()
+	return cfg
+}
+
+func TestPresubmitTopic(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+	// A pull request from a branch called main.
+	getenv = fakeEnv{
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_REPOSITORY": "my-org/my-repo",
+		"GITHUB_SHA":        "abc123",
+		"GITHUB_EVENT_NAME": "pull_request",
+		"GITHUB_REF":        "refs/pull/7/merge",
+		"GITHUB_HEAD_REF":   "main",
+	}.get
+	defer func() { getenv = os.Getenv }()
+
+	dir := t.TempDir()
+	config := `
+project: my-project
+topic: flaky-tests
+branch_topics:
+  main: main-topic
+`
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(config), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	// The continuous topic from the config file isn't for presubmits, and
+	// neither is the topic for the pull request's branch.
+	cfg := loadConfig(t, "-logs_dir", dir)
+	if cfg.mode != modePresubmit || cfg.topicID != "flaky-tests" {
+		t.Errorf("got mode %q and topic %q, want presubmit and flaky-tests", cfg.mode, cfg.topicID)
+	}
+	if cfg.shouldPublish() {
+		t.Errorf("shouldPublish with the topic from the config file = true, want false")
+	}
+
+	config += "presubmit_topic: presubmits\n"
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(config), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	cfg = loadConfig(t, "-logs_dir", dir)
+	if cfg.topicID != "presubmits" {
+		t.Errorf("got topic %q, want presubmits", cfg.topicID)
+	}
+	if !cfg.shouldPublish() {
+		t.Errorf("shouldPublish with presubmit_topic = false, want true")
+	}
+}
+
+func TestConfigRowsCredentials(t *testing.T) {
+	for transport, want := range map[string]bool{"": true, transportPubSub: true, transportHTTP: false, transportFile: false} {
+		cfg := &config{transport: transport}
+		got := false
+		for _, r := range cfg.configRows() {
+			if r.name == "credentials" {
+				got = true
+			}
+		}
+		if got != want {
+			t.Errorf("configRows with transport %q has credentials = %v, want %v", transport, got, want)
+		}
+	}
+}
+
+func TestLoadReportsUnknownKeys(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte("topik: typo\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	(&findFlags{}).register(fs)
+	st := &settings{}
+	st.register(fs)
+	if err := fs.Parse([]string{"-logs_dir", dir}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := st.load(fs, &config{}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !strings.Contains(buf.String(), `Unknown key "topik"`) {
+		t.Errorf("load logged:\n%s\nwant it to report the unknown key topik", buf.String())
+	}
+}
+
+func TestRedact(t *testing.T) {
+	fc := &fileConfig{Redact: []redactRule{
+		{Pattern: `token=\w+`},
+		{Pattern: `(user)=\w+`, Replacement: "${1}=someone"},
+	}}
+	rs, err := fc.redactions()
+	if err != nil {
+		t.Fatalf("redactions: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sponge_log.xml")
+	report := `<testsuites><testsuite name="pkg"><testcase name="TestA"><failure>GET /?token=abc123&amp;user=alice</failure></testcase></testsuite></testsuites>`
+	if err := os.WriteFile(path, []byte(report), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	cfg := &config{formats: []*reportFormat{formatByName("sponge")}, redactions: rs}
+	got, err := readReport(cfg, path)
+	if err != nil {
+		t.Fatalf("readReport: %v", err)
+	}
+	want := `<failure>GET /?[REDACTED]&amp;user=someone</failure>`
+	if !strings.Contains(string(got), want) {
+		t.Errorf("readReport got %s, want it to contain %s", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/credentials/externalaccount"
+	"cloud.google.com/go/auth/credentials/impersonate"
+	"cloud.google.com/go/pubsub"
+)
+
+// The endpoints workload identity federation exchanges the OIDC token with.
+// Tests replace them with a fake.
+var (
+	stsTokenURL = "https://sts.googleapis.com/v1/token"
+	// iamCredentialsURL is formatted with the service account to impersonate.
+	iamCredentialsURL = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:generateAccessToken"
+)
+
+const (
+	// oidcTokenEnv has the OIDC token for --workload_identity_provider, if
+	// --oidc_token_file isn't set.
+	oidcTokenEnv = "FLAKYBOT_OIDC_TOKEN"
+	jwtTokenType = "urn:ietf:params:oauth:token-type:jwt"
+	iamAudience  = "//iam.googleapis.com/"
+)
+
+// credentialFlags are the flags for authenticating to Pub/Sub without a
+// service account key, shared by the commands that publish.
+type credentialFlags struct {
+	impersonateServiceAccount string
+	workloadIdentityProvider  string
+	oidcTokenFile             string
+}
+
+func (f *credentialFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.impersonateServiceAccount, "impersonate_service_account", "", "Email of a service account to impersonate, with the credentials that would be used otherwise.")
+	fs.StringVar(&f.workloadIdentityProvider, "workload_identity_provider", "", "Workload identity provider to exchange an OIDC token with, like projects/123/locations/global/workloadIdentityPools/POOL/providers/PROVIDER.")
+	fs.StringVar(&f.oidcTokenFile, "oidc_token_file", "", "File with the OIDC token for --workload_identity_provider. It's read again whenever a new access token is needed. Defaults to the "+oidcTokenEnv+" environment variable.")
+}
+
+// apply validates the flags and sets them in cfg.
+func (f *credentialFlags) apply(cfg *config) error {
+	if f.oidcTokenFile != "" && f.workloadIdentityProvider == "" {
+		return fmt.Errorf("--oidc_token_file needs --workload_identity_provider")
+	}
+	if f.workloadIdentityProvider != "" {
+		if cfg.serviceAccount != "" {
+			return fmt.Errorf("--service_account and --workload_identity_provider can't both be set")
+		}
+		if f.oidcTokenFile == "" && getenv(oidcTokenEnv) == "" {
+			return fmt.Errorf("no OIDC token for --workload_identity_provider: set --oidc_token_file or %s", oidcTokenEnv)
+		}
+	}
+	cfg.impersonateServiceAccount = f.impersonateServiceAccount
+	cfg.workloadIdentityProvider = f.workloadIdentityProvider
+	cfg.oidcTokenFile = f.oidcTokenFile
+	return nil
+}
+
+// credentialSource describes the credentials Pub/Sub is authenticated with.
+// Workload identity federation takes precedence over --service_account, which
+// takes precedence over application default credentials.
+func (cfg *config) credentialSource() string {
+	var s string
+	switch {
+	case cfg.pubsubEndpoint != "":
+		return "none (Pub/Sub emulator)"
+	case cfg.workloadIdentityProvider != "":
+		s = "workload identity federation with the OIDC token in " + cfg.oidcTokenSource()
+	case cfg.serviceAccount != "":
+		t, err := credentialFileType(cfg.serviceAccount)
+		if err != nil {
+			t = "credentials"
+		}
+		s = fmt.Sprintf("%s file %s", t, cfg.serviceAccount)
+	default:
+		s = "application default credentials"
+	}
+	if cfg.impersonateServiceAccount != "" {
+		s += ", impersonating " + cfg.impersonateServiceAccount
+	}
+	return s
+}
+
+// credentialRow is the credentials setting, for env and -v. They come from a
+// flag unless they're the defaults.
+func (cfg *config) credentialRow() configRow {
+	r := configRow{name: "credentials", value: cfg.credentialSource(), source: sourceFlag}
+	switch {
+	case cfg.pubsubEndpoint != "":
+		r.source = ""
+	case cfg.workloadIdentityProvider == "" && cfg.serviceAccount != "":
+		r.source = cfg.sources["service_account"]
+	case cfg.workloadIdentityProvider == "" && cfg.impersonateServiceAccount == "":
+		r.source = sourceDefault
+	}
+	return r
+}
+
+func (cfg *config) oidcTokenSource() string {
+	if cfg.oidcTokenFile != "" {
+		return cfg.oidcTokenFile
+	}
+	return "$" + oidcTokenEnv
+}
+
+// credentials returns the credentials described by credentialSource.
+func (cfg *config) credentials() (*auth.Credentials, error) {
+	scopes := []string{pubsub.ScopePubSub}
+	if cfg.workloadIdentityProvider != "" {
+		opts := &externalaccount.Options{
+			Audience:             iamAudience + strings.TrimPrefix(cfg.workloadIdentityProvider, iamAudience),
+			SubjectTokenType:     jwtTokenType,
+			TokenURL:             stsTokenURL,
+			Scopes:               scopes,
+			SubjectTokenProvider: &oidcToken{file: cfg.oidcTokenFile},
+		}
+		if cfg.impersonateServiceAccount != "" {
+			opts.ServiceAccountImpersonationURL = fmt.Sprintf(iamCredentialsURL, cfg.impersonateServiceAccount)
+		}
+		return externalaccount.NewCredentials(opts)
+	}
+
+	var creds *auth.Credentials
+	var err error
+	if cfg.serviceAccount != "" {
+		var t credentials.CredType
+		if t, err = credentialFileType(cfg.serviceAccount); err != nil {
+			return nil, err
+		}
+		creds, err = credentials.NewCredentialsFromFile(t, cfg.serviceAccount, &credentials.DetectOptions{Scopes: scopes})
+	} else {
+		creds, err = credentials.DetectDefault(&credentials.DetectOptions{Scopes: scopes})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.impersonateServiceAccount == "" {
+		return creds, nil
+	}
+	return impersonate.NewCredentials(&impersonate.CredentialsOptions{
+		TargetPrincipal: cfg.impersonateServiceAccount,
+		Scopes:          scopes,
+		Credentials:     creds,
+	})
+}
+
+// credentialFileType returns the type of the credentials in a JSON file, like
+// service_account or external_account.
+func credentialFileType(path string) (credentials.CredType, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var f struct {
+		Type credentials.CredType `json:"type"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", fmt.Errorf("parsing %s: %v", path, err)
+	}
+	switch f.Type {
+	case credentials.ServiceAccount, credentials.AuthorizedUser, credentials.ExternalAccount, credentials.ImpersonatedServiceAccount, credentials.ExternalAccountAuthorizedUser:
+		return f.Type, nil
+	}
+	return "", fmt.Errorf("%s has unsupported credentials type %q", path, f.Type)
+}
+
+// oidcToken reads the OIDC token to exchange for Google credentials from a
+// file, or the FLAKYBOT_OIDC_TOKEN environment variable.
+type oidcToken struct {
+	file string
+}
+
+func (t *oidcToken) SubjectToken(context.Context, *externalaccount.RequestOptions) (string, error) {
+	if t.file == "" {
+		if tok := strings.TrimSpace(getenv(oidcTokenEnv)); tok != "" {
+			return tok, nil
+		}
+		return "", fmt.Errorf("%s is empty", oidcTokenEnv)
+	}
+	data, err := os.ReadFile(t.file)
+	if err != nil {
+		return "", err
+	}
+	tok := strings.TrimSpace(string(data))
+	if tok == "" {
+		return "", fmt.Errorf("%s is empty", t.file)
+	}
+	return tok, nil
+}
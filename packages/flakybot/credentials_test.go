@@ -0,0 +1,230 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testProvider  = "projects/123/locations/global/workloadIdentityPools/pool/providers/github"
+	testOIDCToken = "oidc-token"
+)
+
+// newFakeTokenServer returns a fake of the STS and IAM Credentials APIs. STS
+// exchanges testOIDCToken for "sts-token", and IAM Credentials exchanges that
+// for "impersonated-token" for any service account, which it records.
+func newFakeTokenServer(t *testing.T) (srv *httptest.Server, impersonated *[]string) {
+	t.Helper()
+	impersonated = &[]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if got := r.PostForm.Get("subject_token"); got != testOIDCToken {
+			http.Error(w, fmt.Sprintf("subject_token is %q", got), http.StatusUnauthorized)
+			return
+		}
+		if got, want := r.PostForm.Get("audience"), "//iam.googleapis.com/"+testProvider; got != want {
+			http.Error(w, fmt.Sprintf("audience is %q, want %q", got, want), http.StatusBadRequest)
+			return
+		}
+		if got := r.PostForm.Get("subject_token_type"); got != jwtTokenType {
+			http.Error(w, fmt.Sprintf("subject_token_type is %q", got), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "sts-token",
+			"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+			"token_type":        "Bearer",
+			"expires_in":        3600,
+		})
+	})
+	mux.HandleFunc("POST /sa/{email}", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer sts-token" {
+			http.Error(w, fmt.Sprintf("Authorization is %q", got), http.StatusUnauthorized)
+			return
+		}
+		*impersonated = append(*impersonated, strings.TrimSuffix(r.PathValue("email"), ":generateAccessToken"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"accessToken": "impersonated-token",
+			"expireTime":  time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	oldSTS, oldIAM := stsTokenURL, iamCredentialsURL
+	stsTokenURL = srv.URL + "/token"
+	iamCredentialsURL = srv.URL + "/sa/%s:generateAccessToken"
+	t.Cleanup(func() { stsTokenURL, iamCredentialsURL = oldSTS, oldIAM })
+	return srv, impersonated
+}
+
+func TestCredentials(t *testing.T) {
+	srv, impersonated := newFakeTokenServer(t)
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte(testOIDCToken+"\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	// An external account config, like the one google-github-actions/auth
+	// writes, that reads the token from a file.
+	externalAccount := filepath.Join(dir, "external-account.json")
+	data, err := json.Marshal(map[string]any{
+		"type":               "external_account",
+		"audience":           "//iam.googleapis.com/" + testProvider,
+		"subject_token_type": jwtTokenType,
+		"token_url":          srv.URL + "/token",
+		"credential_source":  map[string]any{"file": tokenFile},
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if err := os.WriteFile(externalAccount, data, 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		env              map[string]string
+		cfg              *config
+		wantSource       string
+		wantToken        string
+		wantImpersonated []string
+	}{
+		{
+			name:       "token file",
+			cfg:        &config{workloadIdentityProvider: testProvider, oidcTokenFile: tokenFile},
+			wantSource: "workload identity federation with the OIDC token in " + tokenFile,
+			wantToken:  "sts-token",
+		},
+		{
+			name:       "token env var",
+			env:        map[string]string{oidcTokenEnv: testOIDCToken},
+			cfg:        &config{workloadIdentityProvider: "//iam.googleapis.com/" + testProvider},
+			wantSource: "workload identity federation with the OIDC token in $" + oidcTokenEnv,
+			wantToken:  "sts-token",
+		},
+		{
+			name: "impersonation",
+			cfg: &config{
+				workloadIdentityProvider:  testProvider,
+				oidcTokenFile:             tokenFile,
+				impersonateServiceAccount: "flakybot@p.iam.gserviceaccount.com",
+			},
+			wantSource:       "workload identity federation with the OIDC token in " + tokenFile + ", impersonating flakybot@p.iam.gserviceaccount.com",
+			wantToken:        "impersonated-token",
+			wantImpersonated: []string{"flakybot@p.iam.gserviceaccount.com"},
+		},
+		{
+			name:       "external account config",
+			cfg:        &config{serviceAccount: externalAccount},
+			wantSource: "external_account file " + externalAccount,
+			wantToken:  "sts-token",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getenv = fakeEnv(test.env).get
+			defer func() { getenv = os.Getenv }()
+			*impersonated = nil
+
+			if got := test.cfg.credentialSource(); got != test.wantSource {
+				t.Errorf("credentialSource() = %q, want %q", got, test.wantSource)
+			}
+			creds, err := test.cfg.credentials()
+			if err != nil {
+				t.Fatalf("credentials: %v", err)
+			}
+			tok, err := creds.Token(context.Background())
+			if err != nil {
+				t.Fatalf("Token: %v", err)
+			}
+			if tok.Value != test.wantToken {
+				t.Errorf("Token got %q, want %q", tok.Value, test.wantToken)
+			}
+			if len(*impersonated) != len(test.wantImpersonated) || (len(*impersonated) > 0 && (*impersonated)[0] != test.wantImpersonated[0]) {
+				t.Errorf("impersonated %v, want %v", *impersonated, test.wantImpersonated)
+			}
+		})
+	}
+}
+
+func TestCredentialFlagsErrors(t *testing.T) {
+	dir := t.TempDir()
+	serviceAccount := filepath.Join(dir, "sa.json")
+	if err := os.WriteFile(serviceAccount, []byte(`{"type": "service_account"}`), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	getenv = fakeEnv{}.get
+	defer func() { getenv = os.Getenv }()
+
+	tests := []struct {
+		name  string
+		flags credentialFlags
+		cfg   *config
+		want  string
+	}{
+		{
+			name:  "token file without provider",
+			flags: credentialFlags{oidcTokenFile: "token"},
+			want:  "--oidc_token_file needs --workload_identity_provider",
+		},
+		{
+			name:  "no token",
+			flags: credentialFlags{workloadIdentityProvider: testProvider},
+			want:  "no OIDC token",
+		},
+		{
+			name:  "service account too",
+			flags: credentialFlags{workloadIdentityProvider: testProvider, oidcTokenFile: "token"},
+			cfg:   &config{serviceAccount: serviceAccount},
+			want:  "can't both be set",
+		},
+	}
+	for _, test := range tests {
+		cfg := test.cfg
+		if cfg == nil {
+			cfg = &config{}
+		}
+		err := test.flags.apply(cfg)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: apply got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+
+	// Unknown credential types aren't loaded.
+	unknown := filepath.Join(dir, "unknown.json")
+	if err := os.WriteFile(unknown, []byte(`{"type": "something_else"}`), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if _, err := (&config{serviceAccount: unknown}).credentials(); err == nil {
+		t.Errorf("credentials with an unknown credentials type got no error, want one")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// contentHash returns the hex SHA-256 of a report.
+func contentHash(xml []byte) string {
+	sum := sha256.Sum256(xml)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyKey identifies a report for a commit of a repo, so consumers can
+// drop messages that are published again, like when CI retries the upload
+// step. It's the hex SHA-256 of the repo, commit, and content hash.
+func idempotencyKey(repo, commit, hash string) string {
+	sum := sha256.Sum256([]byte(repo + "\n" + commit + "\n" + hash))
+	return hex.EncodeToString(sum[:])
+}
+
+// skipDuplicates marks the reports that are identical to another one as
+// skipped, so copies of the same report (like a sponge_log.xml that's copied
+// or symlinked into another directory) are only published once. The first
+// path in sorted order is kept.
+func skipDuplicates(results []*fileResult) {
+	sorted := slices.Clone(results)
+	slices.SortFunc(sorted, func(a, b *fileResult) int { return strings.Compare(a.path, b.path) })
+	first := map[string]string{}
+	for _, r := range sorted {
+		if r.err != nil {
+			continue
+		}
+		if path, ok := first[r.hash]; ok {
+			r.skipped = fmt.Sprintf("identical to %s", path)
+			log.Printf("Skipping %s: %s.", r.path, r.skipped)
+			continue
+		}
+		first[r.hash] = r.path
+	}
+}
+
+// ledger is a file with the idempotency keys of the reports that were
+// published, one per line followed by the report's path, so running flakybot
+// again in the same workspace skips them.
+type ledger struct {
+	path string
+
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// openLedger reads the ledger at path. It's OK if it doesn't exist yet.
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{path: path, keys: map[string]bool{}}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if key, _, _ := strings.Cut(s.Text(), " "); key != "" {
+			l.keys[key] = true
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// has reports whether key was already published. A nil ledger has nothing.
+func (l *ledger) has(key string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.keys[key]
+}
+
+// record appends key to the ledger.
+func (l *ledger) record(key, reportPath string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", key, reportPath); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.keys[key] = true
+	return nil
+}
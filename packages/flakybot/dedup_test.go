@@ -0,0 +1,177 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	key := idempotencyKey("a/b", "abc123", contentHash([]byte("report")))
+	if got := idempotencyKey("a/b", "abc123", contentHash([]byte("report"))); got != key {
+		t.Errorf("idempotencyKey isn't stable: got %q, then %q", key, got)
+	}
+	others := []string{
+		idempotencyKey("a/c", "abc123", contentHash([]byte("report"))),
+		idempotencyKey("a/b", "def456", contentHash([]byte("report"))),
+		idempotencyKey("a/b", "abc123", contentHash([]byte("other report"))),
+		// The parts can't run into each other.
+		idempotencyKey("a/b\nabc123", "", contentHash([]byte("report"))),
+	}
+	for _, other := range others {
+		if other == key {
+			t.Errorf("idempotencyKey got %q for different reports", key)
+		}
+	}
+}
+
+// writeReports writes each report to its path in dir, and returns the paths.
+func writeReports(t *testing.T, dir string, reports map[string]string) []string {
+	t.Helper()
+	var paths []string
+	for name, content := range reports {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestPublishSkipsDuplicates(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logs := writeReports(t, t.TempDir(), map[string]string{
+		"a/sponge_log.xml":    "<testsuites>same</testsuites>",
+		"copy/sponge_log.xml": "<testsuites>same</testsuites>",
+		"b/sponge_log.xml":    "<testsuites>different</testsuites>",
+	})
+	cfg := &config{
+		installationID: "123",
+		repo:           "googleapis/repo-automation-bots",
+		commit:         "abc123",
+		buildURL:       "https://example.com",
+		formats:        []*reportFormat{formatByName("sponge")},
+		parallelism:    4,
+	}
+	// The first path in sorted order is published, whatever order the
+	// reports are found in.
+	sort.Sort(sort.Reverse(sort.StringSlice(logs)))
+	p := &fakePublisher{}
+	results, err := publish(context.Background(), cfg, p, logs)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got := len(p.called); got != 2 {
+		t.Errorf("publish published %d messages, want 2", got)
+	}
+	skipped := map[string]string{}
+	for _, r := range results {
+		if r.skipped != "" {
+			rel, _ := filepath.Rel(filepath.Dir(filepath.Dir(r.path)), r.path)
+			skipped[filepath.ToSlash(rel)] = r.skipped
+		}
+	}
+	want := map[string]string{"copy/sponge_log.xml": "identical to " + logs[len(logs)-1]}
+	if diff := cmp.Diff(skipped, want); diff != "" {
+		t.Errorf("publish skipped diff (-got, +want):\n%s", diff)
+	}
+
+	keys := map[string]bool{}
+	for _, data := range p.called {
+		m := &message{}
+		if err := json.Unmarshal([]byte(data), m); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		if m.IdempotencyKey == "" {
+			t.Errorf("published message without an idempotency key: %s", data)
+		}
+		keys[m.IdempotencyKey] = true
+	}
+	if len(keys) != 2 {
+		t.Errorf("published messages have %d different idempotency keys, want 2", len(keys))
+	}
+}
+
+func TestPublishLedger(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	logs := writeReports(t, dir, map[string]string{
+		"a/sponge_log.xml": "<testsuites>a</testsuites>",
+		"b/sponge_log.xml": "<testsuites>b</testsuites>",
+	})
+	badXML := base64.StdEncoding.EncodeToString([]byte("<testsuites>b</testsuites>"))
+	ledgerPath := filepath.Join(dir, "ledger")
+	cfg := &config{
+		installationID: "123",
+		repo:           "googleapis/repo-automation-bots",
+		commit:         "abc123",
+		buildURL:       "https://example.com",
+		formats:        []*reportFormat{formatByName("sponge")},
+		parallelism:    1,
+	}
+	publishOnce := func(fail bool) *fakePublisher {
+		t.Helper()
+		l, err := openLedger(ledgerPath)
+		if err != nil {
+			t.Fatalf("openLedger: %v", err)
+		}
+		cfg.ledger = l
+		p := &fakePublisher{}
+		if fail {
+			p.fail = func(m *pubsub.Message) bool { return strings.Contains(string(m.Data), badXML) }
+		}
+		publish(context.Background(), cfg, p, logs)
+		return p
+	}
+
+	// b fails the first time, so only a is recorded.
+	if got := len(publishOnce(true).called); got != 1 {
+		t.Errorf("first run published %d messages, want 1", got)
+	}
+	if got := len(publishOnce(false).called); got != 1 {
+		t.Errorf("second run published %d messages, want 1 (the one that failed before)", got)
+	}
+	if got := len(publishOnce(false).called); got != 0 {
+		t.Errorf("third run published %d messages, want 0", got)
+	}
+
+	// Dry runs don't record anything.
+	os.Remove(ledgerPath)
+	cfg.dryRun = true
+	publishOnce(false)
+	if _, err := os.Stat(ledgerPath); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the ledger: os.Stat got %v, want it to not exist", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// dryRunPublisher writes messages to a directory, or to out if there is no
+// directory, instead of publishing them.
+type dryRunPublisher struct {
+	dir string
+	out io.Writer
+	// writeXML also writes the decoded xUnit XML of every message.
+	writeXML bool
+
+	mu sync.Mutex
+	n  int
+}
+
+func newDryRunPublisher(cfg *config) (*dryRunPublisher, error) {
+	if cfg.outDir != "" {
+		if err := os.MkdirAll(cfg.outDir, 0755); err != nil {
+			return nil, fmt.Errorf("os.MkdirAll(%q): %v", cfg.outDir, err)
+		}
+	}
+	return &dryRunPublisher{dir: cfg.outDir, out: os.Stdout, writeXML: cfg.outXML}, nil
+}
+
+// publish writes msg and returns where it was written as the server ID.
+func (p *dryRunPublisher) publish(_ context.Context, msg *pubsub.Message) (serverID string, err error) {
+	var xml []byte
+	if p.writeXML {
+		m := &message{}
+		if err := json.Unmarshal(msg.Data, m); err != nil {
+			return "", fmt.Errorf("json.Unmarshal: %v", err)
+		}
+		if xml, err = m.decodeXUnitXML(); err != nil {
+			return "", fmt.Errorf("decoding xunitXML: %v", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n++
+
+	if p.dir == "" {
+		if _, err := fmt.Fprintf(p.out, "%s\n", msg.Data); err != nil {
+			return "", err
+		}
+		if xml != nil {
+			if _, err := fmt.Fprintf(p.out, "%s\n", xml); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("dry-run-%d", p.n), nil
+	}
+
+	path := filepath.Join(p.dir, fmt.Sprintf("message-%d.json", p.n))
+	if err := os.WriteFile(path, msg.Data, 0644); err != nil {
+		return "", err
+	}
+	if xml != nil {
+		xmlPath := filepath.Join(p.dir, fmt.Sprintf("message-%d.xml", p.n))
+		if err := os.WriteFile(xmlPath, xml, 0644); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunPublisher(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logsDir := t.TempDir()
+	content := "<testsuites></testsuites>"
+	if err := os.WriteFile(filepath.Join(logsDir, "sponge_log.xml"), []byte(content), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	outDir := filepath.Join(t.TempDir(), "out")
+	cfg := &config{
+		installationID: "123",
+		repo:           "googleapis/repo-automation-bots",
+		commit:         "abc123",
+		buildURL:       "https://example.com",
+		logsDir:        logsDir,
+		formats:        []*reportFormat{formatByName("sponge")},
+		dryRun:         true,
+		outDir:         outDir,
+		outXML:         true,
+	}
+	logs, err := findLogs(cfg)
+	if err != nil {
+		t.Fatalf("findLogs: %v", err)
+	}
+	p, err := newDryRunPublisher(cfg)
+	if err != nil {
+		t.Fatalf("newDryRunPublisher: %v", err)
+	}
+	if _, err := publish(context.Background(), cfg, p, logs); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "message-1.json"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	msg := &message{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if msg.Repo != cfg.repo || msg.Commit != cfg.commit || msg.BuildURL != cfg.buildURL || msg.Installation.ID != cfg.installationID {
+		t.Errorf("dry run wrote %+v, want it to match %+v", msg, cfg)
+	}
+	xml, err := os.ReadFile(filepath.Join(outDir, "message-1.xml"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	if string(xml) != content {
+		t.Errorf("dry run wrote XML %q, want %q", xml, content)
+	}
+
+	buf := &bytes.Buffer{}
+	stdout := &dryRunPublisher{out: buf}
+	if _, err := publish(context.Background(), cfg, stdout, logs); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != string(data) {
+		t.Errorf("dry run to stdout wrote %s, want %s", got, data)
+	}
+}
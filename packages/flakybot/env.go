@@ -0,0 +1,113 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// envMain runs the env command, which prints the config publish would use, and
+// where each value came from. It returns the exit code.
+func envMain(args []string) int {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: flakybot env [flags]
+
+Env prints the config publish would use, and where each value came from: a
+flag, a FLAKYBOT_ environment variable, the CI system, the config file, the
+local git checkout, or the default. Values that couldn't be detected are
+shown as -.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	cf := &configFlags{}
+	cf.register(fs)
+	find := &findFlags{}
+	find.register(fs)
+	st := &settings{}
+	st.register(fs)
+	asJSON := fs.Bool("json", false, "Print the config as JSON instead of a table.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg := &config{}
+	if err := st.load(fs, cfg); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	if err := cf.apply(cfg, st); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	if err := find.apply(cfg, st); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	// env doesn't take the transport flags, but shows the settings from the
+	// environment and the config file.
+	cfg.transport = st.string("transport", transportPubSub, st.file.Transport)
+	cfg.httpURL = st.string("http_url", "", st.file.HTTPURL)
+	cfg.presubmitHTTPURL = st.string("presubmit_http_url", "", st.file.PresubmitHTTPURL)
+	cfg.detect()
+	var err error
+	if *asJSON {
+		err = writeEnvJSON(os.Stdout, cfg.configRows())
+	} else {
+		err = writeEnvTable(os.Stdout, cfg.configRows())
+	}
+	if err != nil {
+		log.Printf("Error writing config: %v", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// envValue is a setting in the JSON output of the env command.
+type envValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source,omitempty"`
+}
+
+func writeEnvJSON(w io.Writer, rows []configRow) error {
+	values := map[string]envValue{}
+	for _, r := range rows {
+		values[r.name] = envValue{Value: r.value, Source: r.source}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
+
+func writeEnvTable(w io.Writer, rows []configRow) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+	for _, r := range rows {
+		value, source := r.value, r.source
+		if value == "" {
+			value, source = "-", ""
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.name, value, source)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,67 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteEnv(t *testing.T) {
+	rows := []configRow{
+		{name: "repo", value: "googleapis/repo-automation-bots", source: "GitLab CI"},
+		{name: "installation_id", value: "6370238", source: sourceKnownOrgs},
+		{name: "build_url", source: sourceDefault},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeEnvTable(buf, rows); err != nil {
+		t.Fatalf("writeEnvTable: %v", err)
+	}
+	var got [][]string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		got = append(got, strings.Fields(line))
+	}
+	want := [][]string{
+		{"NAME", "VALUE", "SOURCE"},
+		{"repo", "googleapis/repo-automation-bots", "GitLab", "CI"},
+		{"installation_id", "6370238", "known", "installations"},
+		{"build_url", "-"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("writeEnvTable got diff (-got, +want):\n%s", diff)
+	}
+
+	buf.Reset()
+	if err := writeEnvJSON(buf, rows); err != nil {
+		t.Fatalf("writeEnvJSON: %v", err)
+	}
+	var gotJSON map[string]envValue
+	if err := json.Unmarshal(buf.Bytes(), &gotJSON); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	wantJSON := map[string]envValue{
+		"repo":            {Value: "googleapis/repo-automation-bots", Source: "GitLab CI"},
+		"installation_id": {Value: "6370238", Source: sourceKnownOrgs},
+		"build_url":       {Source: sourceDefault},
+	}
+	if diff := cmp.Diff(gotJSON, wantJSON); diff != "" {
+		t.Errorf("writeEnvJSON got diff (-got, +want):\n%s", diff)
+	}
+}
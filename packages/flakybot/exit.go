@@ -0,0 +1,75 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "log"
+
+// Exit codes of the publish command, so CI can tell failures apart. The other
+// commands exit with exitConfigError for bad flags and exitError otherwise.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitConfigError = 2
+	// exitNoReports is for when no test reports were found.
+	exitNoReports = 3
+	// exitPartialFailure is for when some reports were published, but others
+	// couldn't be.
+	exitPartialFailure = 4
+	// exitPublishFailed is for when no reports could be published.
+	exitPublishFailed = 5
+)
+
+// The values of --on_empty and --on_publish_error.
+const (
+	policyFail   = "fail"
+	policyWarn   = "warn"
+	policyIgnore = "ignore"
+)
+
+// noReportsExitCode logs that no reports were found, and returns the exit code
+// for the --on_empty policy.
+func noReportsExitCode(cfg *config, policy string) int {
+	switch policy {
+	case policyIgnore:
+		log.Print("No test reports found.")
+		return exitOK
+	case policyWarn:
+		logNoReports(cfg)
+		log.Print("Warning: exiting successfully anyway, since --on_empty=warn.")
+		return exitOK
+	}
+	logNoReports(cfg)
+	return exitNoReports
+}
+
+// publishExitCode returns the exit code for the --on_publish_error policy,
+// after publishing results failed with err.
+func publishExitCode(results []*fileResult, err error, policy string) int {
+	if err == nil {
+		return exitOK
+	}
+	code := exitPublishFailed
+	for _, r := range results {
+		if r.err == nil && r.skipped == "" && len(r.spooled) == 0 {
+			code = exitPartialFailure
+			break
+		}
+	}
+	if policy == policyWarn {
+		log.Printf("Warning: exiting successfully anyway, since --on_publish_error=warn. Would have exited with %d.", code)
+		return exitOK
+	}
+	return code
+}
@@ -0,0 +1,134 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublishExitCode(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	failed := &fileResult{path: "a", err: errors.New("failed")}
+	published := &fileResult{path: "b", ids: []string{"1"}}
+	skipped := &fileResult{path: "c", skipped: "identical to a"}
+	err := errors.New("some files failed")
+	tests := []struct {
+		name    string
+		results []*fileResult
+		err     error
+		policy  string
+		want    int
+	}{
+		{name: "success", results: []*fileResult{published, skipped}, policy: policyFail, want: exitOK},
+		{name: "partial", results: []*fileResult{failed, published}, err: err, policy: policyFail, want: exitPartialFailure},
+		{name: "total", results: []*fileResult{failed, skipped}, err: err, policy: policyFail, want: exitPublishFailed},
+		{name: "no results", err: err, policy: policyFail, want: exitPublishFailed},
+		{name: "warn", results: []*fileResult{failed}, err: err, policy: policyWarn, want: exitOK},
+	}
+	for _, test := range tests {
+		if got := publishExitCode(test.results, test.err, test.policy); got != test.want {
+			t.Errorf("%s: publishExitCode = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPublishMainExitCodes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+
+	emptyDir := t.TempDir()
+	logsDir := t.TempDir()
+	writeReports(t, logsDir, map[string]string{
+		"good/sponge_log.xml": "<testsuites>good</testsuites>",
+		"bad/sponge_log.xml":  "<testsuites>bad</testsuites>",
+	})
+	// The endpoint rejects the bad report, or everything.
+	rejectAll := false
+	bad := base64.StdEncoding.EncodeToString([]byte("<testsuites>bad</testsuites>"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if rejectAll || strings.Contains(string(body), bad) {
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id": "1"}`))
+	}))
+	defer srv.Close()
+
+	flags := []string{
+		"-repo", "googleapis/repo-automation-bots",
+		"-commit_hash", "abc123",
+		"-build_url", "local",
+		"-transport", "http",
+		"-http_url", srv.URL,
+		"-max_attempts", "1",
+	}
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		rejectAll bool
+		want      int
+	}{
+		{name: "no reports", args: []string{"-logs_dir", emptyDir}, want: exitNoReports},
+		{name: "no reports warn", args: []string{"-logs_dir", emptyDir, "-on_empty", "warn"}, want: exitOK},
+		{name: "no reports ignore", args: []string{"-logs_dir", emptyDir, "-on_empty", "ignore"}, want: exitOK},
+		{name: "no reports env", env: map[string]string{"FLAKYBOT_ON_EMPTY": "ignore"}, args: []string{"-logs_dir", emptyDir}, want: exitOK},
+		{name: "bad policy", args: []string{"-logs_dir", emptyDir, "-on_empty", "maybe"}, want: exitConfigError},
+		{name: "bad publish policy", args: []string{"-logs_dir", logsDir, "-on_publish_error", "ignore"}, want: exitConfigError},
+		{name: "partial", args: []string{"-logs_dir", logsDir}, want: exitPartialFailure},
+		{name: "partial warn", args: []string{"-logs_dir", logsDir, "-on_publish_error", "warn"}, want: exitOK},
+		{name: "total", args: []string{"-logs_dir", logsDir}, rejectAll: true, want: exitPublishFailed},
+		{name: "total warn", args: []string{"-logs_dir", logsDir, "-on_publish_error", "warn"}, rejectAll: true, want: exitOK},
+		// A transport that can't be created is a config error, even with
+		// --on_publish_error=warn.
+		{name: "bad transport", args: []string{"-logs_dir", logsDir, "-http_url", "ftp://example.com", "-on_publish_error", "warn"}, want: exitConfigError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := fakeEnv{"FLAKYBOT_HTTP_SECRET": "secret"}
+			for k, v := range test.env {
+				env[k] = v
+			}
+			getenv = env.get
+			defer func() { getenv = os.Getenv }()
+			rejectAll = test.rejectAll
+
+			if got := run(append(append([]string{}, flags...), test.args...)); got != test.want {
+				t.Errorf("run got exit code %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	// Config errors are told apart from everything else.
+	getenv = fakeEnv{}.get
+	defer func() { getenv = os.Getenv }()
+	if got := run([]string{"-logs_dir", filepath.Join(emptyDir, "missing"), "-commit_hash", "abc123"}); got != exitConfigError {
+		t.Errorf("run without a repo got exit code %d, want %d", got, exitConfigError)
+	}
+}
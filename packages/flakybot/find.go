@@ -0,0 +1,285 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// defaultExcludes aren't searched for logs, unless --no_default_excludes is
+// set.
+var defaultExcludes = []string{"**/.git", "**/node_modules"}
+
+// stringsFlag is a flag.Value that can be repeated to build a list.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// findFlags are the flags controlling where flakybot looks for reports.
+type findFlags struct {
+	logsDir           string
+	formats           string
+	includes          stringsFlag
+	excludes          stringsFlag
+	maxDepth          int
+	respectGitignore  bool
+	skipUnreadable    bool
+	noDefaultExcludes bool
+}
+
+func (f *findFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.logsDir, "logs_dir", ".", "The directory to look for logs in. Defaults to current directory.")
+	fs.Var(&f.includes, "include", "Glob pattern (** allowed) for reports to publish, relative to --logs_dir. Can be repeated. Defaults to any file matching --formats, like **/*sponge_log.xml.")
+	fs.Var(&f.excludes, "exclude", "Glob pattern (** allowed) for files and directories to skip, relative to --logs_dir. Can be repeated. .git and node_modules are also skipped, unless --no_default_excludes is set.")
+	fs.BoolVar(&f.noDefaultExcludes, "no_default_excludes", false, "Also search .git and node_modules directories, like for reports written under nested packages' node_modules.")
+	fs.IntVar(&f.maxDepth, "max_depth", 0, "Maximum directory depth to search below --logs_dir. 1 means only search --logs_dir itself. 0 means no limit.")
+	fs.BoolVar(&f.respectGitignore, "respect_gitignore", false, "Skip files and directories ignored by .gitignore files in --logs_dir.")
+	fs.BoolVar(&f.skipUnreadable, "skip_unreadable", false, "Skip and report files and directories that can't be read, instead of failing.")
+	fs.StringVar(&f.formats, "formats", "sponge", "Comma separated list of test report formats to look for. One or more of "+strings.Join(formatNames(reportFormats), ",")+". Reports are converted to sponge_log.xml-style xUnit XML before publishing.")
+}
+
+// apply validates the flags and sets them in cfg. Flags that weren't set can
+// come from the environment or config file in st.
+func (f *findFlags) apply(cfg *config, st *settings) error {
+	logsDir := st.string("logs_dir", f.logsDir, st.file.LogsDir)
+	// Paths in the config file are relative to the file.
+	if st.sources["logs_dir"] == st.filePath && !filepath.IsAbs(logsDir) {
+		logsDir = filepath.Join(filepath.Dir(st.filePath), logsDir)
+	}
+	formatList := st.strings("formats", strings.Split(f.formats, ","), st.file.Formats)
+	formats, err := parseFormats(strings.Join(formatList, ","))
+	if err != nil {
+		return fmt.Errorf("invalid formats: %v", err)
+	}
+	includes := st.strings("include", f.includes, st.file.Include)
+	excludes := st.strings("exclude", f.excludes, st.file.Exclude)
+	if err := validatePatterns(append(append([]string{}, includes...), excludes...)); err != nil {
+		return fmt.Errorf("invalid include or exclude: %v", err)
+	}
+	cfg.logsDir = logsDir
+	cfg.formats = formats
+	cfg.includes = includes
+	cfg.excludes = excludes
+	cfg.maxDepth = f.maxDepth
+	cfg.respectGitignore = f.respectGitignore
+	cfg.skipUnreadable = f.skipUnreadable
+	cfg.noDefaultExcludes = f.noDefaultExcludes
+	return nil
+}
+
+// logNoReports explains that findLogs didn't find anything.
+func logNoReports(cfg *config) {
+	log.Printf("No test reports (formats: %s) found in %s. Did you forget to generate sponge_log.xml?", strings.Join(formatNames(cfg.formats), ","), cfg.logsDir)
+}
+
+// validatePatterns checks the include and exclude patterns are valid globs.
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if !doublestar.ValidatePattern(p) {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// findLogs searches cfg.logsDir for test reports in any of cfg.formats and
+// returns their paths.
+//
+// If cfg.includes is set, any file matching one of them is a candidate, no
+// matter what it's called. Otherwise, the file name must match one of the
+// formats. Paths matching cfg.excludes or, unless cfg.noDefaultExcludes is
+// set, the defaultExcludes are skipped.
+func findLogs(cfg *config) ([]string, error) {
+	dir := cfg.logsDir
+	var excludes []string
+	if !cfg.noDefaultExcludes {
+		excludes = append(excludes, defaultExcludes...)
+	}
+	excludes = append(excludes, cfg.excludes...)
+	ignores := map[string][]ignoreRule{}
+	var paths []string
+	var unreadable int
+
+	walk := func(p string, dirEntry fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(dir, p)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			if !cfg.skipUnreadable || rel == "." {
+				return err
+			}
+			log.Printf("Skipping %s: %v", p, err)
+			unreadable++
+			if dirEntry != nil && dirEntry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if rel == "." {
+			if cfg.respectGitignore {
+				return loadGitignore(ignores, p, rel)
+			}
+			return nil
+		}
+		isDir := dirEntry.IsDir()
+		if matchAny(excludes, rel) || (cfg.respectGitignore && ignored(ignores, rel, isDir)) {
+			if isDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// Like find -maxdepth, entries directly in dir have depth 1.
+		depth := strings.Count(rel, "/") + 1
+		if isDir {
+			if cfg.maxDepth > 0 && depth >= cfg.maxDepth {
+				return fs.SkipDir
+			}
+			if cfg.respectGitignore {
+				return loadGitignore(ignores, p, rel)
+			}
+			return nil
+		}
+		if len(cfg.includes) > 0 && !matchAny(cfg.includes, rel) {
+			return nil
+		}
+		f, err := detectFormat(p, cfg.formats, len(cfg.includes) > 0)
+		if err != nil {
+			if cfg.skipUnreadable {
+				log.Printf("Skipping %s: %v", p, err)
+				unreadable++
+				return nil
+			}
+			return err
+		}
+		if f == nil {
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	}
+	if err := filepath.WalkDir(dir, walk); err != nil {
+		return nil, err
+	}
+	if unreadable > 0 {
+		log.Printf("Skipped %d unreadable files or directories in %s.", unreadable, dir)
+	}
+	return paths, nil
+}
+
+// matchAny reports whether rel matches any of the doublestar patterns.
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRule is a single line of a .gitignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// loadGitignore reads the .gitignore file in dir (at rel from the logs
+// directory), if there is one.
+func loadGitignore(ignores map[string][]ignoreRule, dir, rel string) error {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// Patterns without a slash (other than a trailing one) match at any
+		// depth. Otherwise, they're relative to the .gitignore file.
+		if strings.Contains(line, "/") {
+			r.pattern = strings.TrimPrefix(line, "/")
+		} else {
+			r.pattern = "**/" + line
+		}
+		rules = append(rules, r)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	ignores[rel] = rules
+	return nil
+}
+
+// ignored reports whether rel is ignored by the .gitignore files loaded so
+// far. Like git, the last matching rule wins and deeper files take precedence.
+func ignored(ignores map[string][]ignoreRule, rel string, isDir bool) bool {
+	// Collect the directories containing rel, outermost first.
+	dirs := []string{"."}
+	for d := path.Dir(rel); d != "."; d = path.Dir(d) {
+		dirs = append(dirs[:1], append([]string{d}, dirs[1:]...)...)
+	}
+	result := false
+	for _, d := range dirs {
+		target := rel
+		if d != "." {
+			target = strings.TrimPrefix(rel, d+"/")
+		}
+		for _, r := range ignores[d] {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if ok, _ := doublestar.Match(r.pattern, target); ok {
+				result = !r.negate
+			}
+		}
+	}
+	return result
+}
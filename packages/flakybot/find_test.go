@@ -0,0 +1,153 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindLogs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tmpdir := t.TempDir()
+	files := map[string]string{
+		"sponge_log.xml":                       "unused",
+		"a/sponge_log.xml":                     "unused",
+		"a/b/sponge_log.xml":                   "unused",
+		"a/b/c/sponge_log.xml":                 "unused",
+		"node_modules/dep/sponge_log.xml":      "unused",
+		".git/sponge_log.xml":                  "unused",
+		"vendor/sponge_log.xml":                "unused",
+		"cache/sponge_log.xml":                 "unused",
+		"cache/keep/sponge_log.xml":            "unused",
+		"results/go.xml":                       "<testsuites></testsuites>",
+		"results/nunit.xml":                    "<test-run></test-run>",
+		".gitignore":                           "cache/\n",
+		"cache/.gitignore":                     "# Keep this one.\n!keep/\n",
+		"target/surefire-reports/TEST-Foo.xml": "<testsuite></testsuite>",
+	}
+	for name, content := range files {
+		p := filepath.Join(tmpdir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+	}
+
+	sponge := []*reportFormat{formatByName("sponge")}
+	tests := []struct {
+		name string
+		cfg  *config
+		want []string
+	}{
+		{
+			name: "default",
+			cfg:  &config{formats: sponge},
+			want: []string{"a/b/c/sponge_log.xml", "a/b/sponge_log.xml", "a/sponge_log.xml", "cache/keep/sponge_log.xml", "cache/sponge_log.xml", "sponge_log.xml", "vendor/sponge_log.xml"},
+		},
+		{
+			name: "exclude",
+			cfg:  &config{formats: sponge, excludes: []string{"vendor", "**/b"}},
+			want: []string{"a/sponge_log.xml", "cache/keep/sponge_log.xml", "cache/sponge_log.xml", "sponge_log.xml"},
+		},
+		{
+			name: "no default excludes",
+			cfg:  &config{formats: sponge, noDefaultExcludes: true, excludes: []string{"a", "cache", "vendor"}},
+			want: []string{".git/sponge_log.xml", "node_modules/dep/sponge_log.xml", "sponge_log.xml"},
+		},
+		{
+			name: "max depth",
+			cfg:  &config{formats: sponge, maxDepth: 2},
+			want: []string{"a/sponge_log.xml", "cache/sponge_log.xml", "sponge_log.xml", "vendor/sponge_log.xml"},
+		},
+		{
+			name: "gitignore",
+			cfg:  &config{formats: sponge, respectGitignore: true, excludes: []string{"vendor"}},
+			want: []string{"a/b/c/sponge_log.xml", "a/b/sponge_log.xml", "a/sponge_log.xml", "sponge_log.xml"},
+		},
+		{
+			name: "include",
+			cfg:  &config{formats: []*reportFormat{formatByName("sponge"), formatByName("nunit")}, includes: []string{"results/*.xml", "**/TEST-*.xml"}},
+			want: []string{"results/go.xml", "results/nunit.xml", "target/surefire-reports/TEST-Foo.xml"},
+		},
+		{
+			name: "junit",
+			cfg:  &config{formats: []*reportFormat{formatByName("junit")}},
+			want: []string{"results/go.xml", "target/surefire-reports/TEST-Foo.xml"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.cfg.logsDir = tmpdir
+			logs, err := findLogs(test.cfg)
+			if err != nil {
+				t.Fatalf("findLogs: %v", err)
+			}
+			var got []string
+			for _, l := range logs {
+				rel, err := filepath.Rel(tmpdir, l)
+				if err != nil {
+					t.Fatalf("filepath.Rel: %v", err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("findLogs got diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFindLogsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tmpdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpdir, "sponge_log.xml"), []byte("unused"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	locked := filepath.Join(tmpdir, "locked")
+	if err := os.Mkdir(locked, 0000); err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+	defer os.Chmod(locked, 0777)
+
+	cfg := &config{logsDir: tmpdir, formats: []*reportFormat{formatByName("sponge")}}
+	if _, err := findLogs(cfg); err == nil {
+		t.Errorf("findLogs got nil error for unreadable directory, want an error")
+	}
+	cfg.skipUnreadable = true
+	logs, err := findLogs(cfg)
+	if err != nil {
+		t.Fatalf("findLogs with skipUnreadable: %v", err)
+	}
+	if got := len(logs); got != 1 {
+		t.Errorf("findLogs with skipUnreadable found %d files, want 1", got)
+	}
+}
@@ -12,29 +12,48 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Command flakybot searches for sponge_log.xml files and publishes them to
-// Pub/Sub.
+// Command flakybot searches for sponge_log.xml files (and, optionally, other
+// test report formats) and publishes them to Pub/Sub.
 //
 // You can run it locally by running:
 //
 //	go build
 //	./flakybot -repo=my-org/my-repo -installation_id=123 -project=my-project
+//
+// To see which issues the bot would open or close, without publishing
+// anything, run:
+//
+//	./flakybot analyze -logs_dir=.
+//
+// Run ./flakybot help to see every command.
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func main() {
@@ -42,56 +61,359 @@ func main() {
 	log.SetPrefix("[FlakyBot] ")
 	log.SetOutput(os.Stderr)
 
-	repo := flag.String("repo", "", "The repo this is for. Defaults to auto-detect from Kokoro environment. If that doesn't work, if your repo is github.com/GoogleCloudPlatform/golang-samples, --repo should be GoogleCloudPlatform/golang-samples")
-	installationID := flag.String("installation_id", "", "GitHub installation ID. Defaults to auto-detect. If your repo is not part of GoogleCloudPlatform or googleapis set this to the GitHub installation ID for your repo. See https://github.com/googleapis/repo-automation-bots/issues.")
-	projectID := flag.String("project", "repo-automation-bots", "Project ID to publish to. Defaults to repo-automation-bots.")
-	topicID := flag.String("topic", "passthrough", "Pub/Sub topic to publish to. Defaults to passthrough.")
-	logsDir := flag.String("logs_dir", ".", "The directory to look for logs in. Defaults to current directory.")
-	commit := flag.String("commit_hash", "", "Long form commit hash this build is being run for. Defaults to the KOKORO_GIT_COMMIT environment variable.")
-	serviceAccount := flag.String("service_account", "", "Path to service account to use instead of Trampoline default or client library auto-detection.")
-	buildURL := flag.String("build_url", "", "Build URL (markdown OK). Defaults to detect from Kokoro.")
+	os.Exit(run(os.Args[1:]))
+}
+
+// command is a flakybot subcommand.
+type command struct {
+	name  string
+	short string
+	main  func(args []string) int
+}
+
+// commands are the flakybot subcommands. Invocations without one run publish.
+var commands = []*command{
+	{"publish", "Find test reports and publish them to Flaky Bot (the default).", publishMain},
+	{"validate", "Check the config and test reports, without publishing anything.", validateMain},
+	{"analyze", "Print the issues Flaky Bot would open or close for each report.", analyzeMain},
+	{"flush", "Publish the messages saved with --spool_dir.", flushMain},
+	{"env", "Print the config detected from the flags and environment.", envMain},
+}
+
+// run runs the subcommand named by args[0], or publish if args don't start
+// with one, and returns the exit code.
+func run(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return publishMain(args)
+	}
+	name := args[0]
+	if name == "help" {
+		if len(args) > 1 {
+			if c := commandByName(args[1]); c != nil {
+				return c.main([]string{"-h"})
+			}
+		}
+		usage(os.Stdout)
+		return exitOK
+	}
+	c := commandByName(name)
+	if c == nil {
+		log.Printf("Unknown command %q.", name)
+		usage(os.Stderr)
+		return exitConfigError
+	}
+	return c.main(args[1:])
+}
 
-	flag.Parse()
+// parseFlags parses args with fs. If it returns false, the command should exit
+// with code: exitOK for -h (the usage was already printed), or
+// exitConfigError for a bad flag.
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitConfigError, false
+	}
+	return exitOK, true
+}
+
+func commandByName(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// usage prints the list of commands.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: flakybot [command] [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(w, "\nRun flakybot help <command> for the flags of a command.\n")
+}
+
+// configFlags are the flags describing the repo, build, and where to publish,
+// shared by the commands that detect the config.
+type configFlags struct {
+	repo              string
+	installationID    string
+	projectID         string
+	topicID           string
+	presubmitTopic    string
+	commit            string
+	serviceAccount    string
+	buildURL          string
+	mode              string
+	githubAppID       string
+	githubAppKey      string
+	githubAPIURL      string
+	installationsFile string
+	creds             credentialFlags
+}
+
+// defaultProject and defaultTopic are the topic Flaky Bot reads continuous
+// results from.
+const (
+	defaultProject = "repo-automation-bots"
+	defaultTopic   = "passthrough"
+)
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.repo, "repo", "", "The repo this is for. Defaults to auto-detect from Kokoro environment. If that doesn't work, if your repo is github.com/GoogleCloudPlatform/golang-samples, --repo should be GoogleCloudPlatform/golang-samples")
+	fs.StringVar(&f.installationID, "installation_id", "", "GitHub installation ID. Defaults to auto-detect. If your repo is not part of GoogleCloudPlatform or googleapis set this to the GitHub installation ID for your repo. See https://github.com/googleapis/repo-automation-bots/issues.")
+	fs.StringVar(&f.projectID, "project", defaultProject, "Project ID to publish to. Defaults to repo-automation-bots.")
+	fs.StringVar(&f.topicID, "topic", defaultTopic, "Pub/Sub topic to publish to. Defaults to passthrough.")
+	fs.StringVar(&f.presubmitTopic, "presubmit_topic", "", "Pub/Sub topic to publish presubmit results to, instead of --topic. Presubmit results are only published to it, unless --allow_presubmit is set.")
+	fs.StringVar(&f.commit, "commit_hash", "", "Long form commit hash this build is being run for. Defaults to the KOKORO_GIT_COMMIT environment variable.")
+	fs.StringVar(&f.serviceAccount, "service_account", "", "Path to service account to use instead of Trampoline default or client library auto-detection.")
+	fs.StringVar(&f.buildURL, "build_url", "", "Build URL (markdown OK). Defaults to detect from Kokoro.")
+	fs.StringVar(&f.mode, "mode", "", "presubmit or continuous. Defaults to presubmit for pull request builds and continuous otherwise.")
+	fs.StringVar(&f.githubAppID, "github_app_id", "", "ID of the GitHub App to look up the installation ID of, with --github_app_key.")
+	fs.StringVar(&f.githubAppKey, "github_app_key", "", "Path to the PEM private key of --github_app_id, used to look up the installation ID with the GitHub API.")
+	fs.StringVar(&f.githubAPIURL, "github_api_url", defaultGitHubAPIURL, "GitHub API URL to look up installation IDs with. The host is also used to match GitHub Enterprise entries in --installations_file.")
+	fs.StringVar(&f.installationsFile, "installations_file", "", "YAML or JSON file mapping owners and owner/repo patterns to installation IDs, on top of the built-in ones.")
+	f.creds.register(fs)
+}
+
+// apply validates the flags and sets them in cfg. Flags that weren't set can
+// come from the environment or config file in st. The repo and installation
+// ID in the config file are only used if they can't be detected from the CI
+// environment; see detect.
+func (f *configFlags) apply(cfg *config, st *settings) error {
+	if f.mode != "" && f.mode != modePresubmit && f.mode != modeContinuous {
+		return fmt.Errorf("--mode must be %s or %s, got %q", modePresubmit, modeContinuous, f.mode)
+	}
+	cfg.projectID = st.string("project", f.projectID, st.file.Project)
+	cfg.topicID = st.string("topic", f.topicID, st.file.Topic)
+	cfg.presubmitTopic = st.string("presubmit_topic", f.presubmitTopic, st.file.PresubmitTopic)
+	cfg.repo = st.string("repo", f.repo, "")
+	cfg.installationID = st.string("installation_id", f.installationID, "")
+	cfg.commit = st.flagOnly("commit_hash", f.commit)
+	cfg.serviceAccount = st.flagOnly("service_account", f.serviceAccount)
+	cfg.buildURL = st.flagOnly("build_url", f.buildURL)
+	cfg.mode = st.flagOnly("mode", f.mode)
+	if err := f.creds.apply(cfg); err != nil {
+		return err
+	}
+
+	cfg.githubHost = githubHost(f.githubAPIURL)
+	installations, err := loadInstallations(f.installationsFile, getenv("FLAKYBOT_INSTALLATIONS"))
+	if err != nil {
+		return fmt.Errorf("could not load installation IDs: %v", err)
+	}
+	cfg.installationTable = installations
+	if f.githubAppID != "" || f.githubAppKey != "" {
+		if f.githubAppID == "" || f.githubAppKey == "" {
+			return fmt.Errorf("--github_app_id and --github_app_key must be set together")
+		}
+		r, err := newInstallationResolver(f.githubAppID, f.githubAppKey, f.githubAPIURL)
+		if err != nil {
+			return fmt.Errorf("could not read --github_app_key: %v", err)
+		}
+		cfg.installations = r
+	}
+	return nil
+}
+
+// publishMain finds test reports and publishes them.
+func publishMain(args []string) (code int) {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: flakybot [publish] [flags]
+
+Publish finds test reports and publishes them to Pub/Sub for Flaky Bot. It's
+the default command. Run flakybot help to see the other commands.
+
+Exit codes: 0 on success, 2 for a bad flag or config, 3 if no test reports
+were found, 4 if some reports couldn't be published, 5 if none could be, and
+1 for any other error. See --on_empty and --on_publish_error.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	cf := &configFlags{}
+	cf.register(fs)
+	dryRun := fs.Bool("dry_run", false, "Write the messages that would be published to --out_dir, or stdout, instead of publishing them.")
+	outDir := fs.String("out_dir", "", "Directory to write messages to. Implies --dry_run.")
+	maxMessageBytes := fs.Int("max_message_bytes", defaultMaxMessageBytes, "Maximum size of a message. Bigger reports are split into several messages.")
+	compress := fs.Bool("compress", false, "Gzip the xUnit XML before base64 encoding it, to make messages smaller.")
+	outXML := fs.Bool("out_xml", false, "With --dry_run, also write the decoded xUnit XML of every message.")
+	parallelism := fs.Int("parallelism", 8, "Maximum number of log files to publish at once.")
+	maxAttempts := fs.Int("max_attempts", defaultRetryPolicy.maxAttempts, "Maximum number of times to try publishing a message that fails with a transient error.")
+	initialBackoff := fs.Duration("initial_backoff", defaultRetryPolicy.initialBackoff, "How long to wait before retrying a message the first time. Doubles after every attempt.")
+	maxBackoff := fs.Duration("max_backoff", defaultRetryPolicy.maxBackoff, "Maximum time to wait between attempts.")
+	publishDeadline := fs.Duration("publish_deadline", defaultRetryPolicy.deadline, "Maximum time to spend publishing a message, including retries. 0 means no limit.")
+	allowPresubmit := fs.Bool("allow_presubmit", false, "Publish results in presubmit mode to --topic, which is for continuous builds. By default, presubmit results are only published to --presubmit_topic, so a pull request can't open issues for the main branch.")
+	spoolDir := fs.String("spool_dir", "", "Directory to save messages that fail to publish with a transient error to, so the flush command can publish them later.")
+	summaryJSON := fs.String("summary_json", "", "File to write a JSON summary of the run to: the outcome of each file, the test counts, and the config.")
+	summary := fs.String("summary", "", "Also write a summary of the run in this format. github writes markdown to the GitHub Actions step summary.")
+	onEmptyFlag := fs.String("on_empty", policyFail, "What to do if no test reports are found: fail, warn (exit successfully with a warning), or ignore.")
+	onPublishErrorFlag := fs.String("on_publish_error", policyFail, "What to do if reports can't be published: fail, or warn (exit successfully with a warning).")
+	ledgerPath := fs.String("ledger", "", "File to record the reports that were published in, so running flakybot again in the same workspace skips them.")
+	ordered := fs.Bool("ordered", false, "Publish the messages for a repo in order, with the repo as the ordering key. The subscription needs message ordering enabled.")
+	pubsubEndpoint := fs.String("pubsub_endpoint", "", "Pub/Sub endpoint to publish to without TLS or credentials, like a local emulator at localhost:8085. Defaults to the PUBSUB_EMULATOR_HOST environment variable.")
+	transport := fs.String("transport", transportPubSub, "How to send messages: pubsub, http (POST them to --http_url), or file (write them to --out_dir, or stdout).")
+	httpURL := fs.String("http_url", "", "URL to POST messages to with --transport=http.")
+	presubmitHTTPURL := fs.String("presubmit_http_url", "", "URL to POST presubmit results to with --transport=http, instead of --http_url. Presubmit results are only sent to it, unless --allow_presubmit is set.")
+	httpSecretFile := fs.String("http_secret_file", "", "File with the secret to sign requests with for --transport=http. Defaults to the FLAKYBOT_HTTP_SECRET environment variable.")
+	find := &findFlags{}
+	find.register(fs)
+	st := &settings{}
+	st.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
 
 	cfg := &config{
-		projectID:      *projectID,
-		topicID:        *topicID,
-		repo:           *repo,
-		installationID: *installationID,
-		commit:         *commit,
-		logsDir:        *logsDir,
-		serviceAccount: *serviceAccount,
-		buildURL:       *buildURL,
+		dryRun:         *dryRun,
+		outDir:         *outDir,
+		outXML:         *outXML,
+		allowPresubmit: *allowPresubmit,
+
+		maxMessageBytes: *maxMessageBytes,
+		compress:        *compress,
+		parallelism:     *parallelism,
+		spoolDir:        *spoolDir,
+		pubsubEndpoint:  firstNonEmpty(*pubsubEndpoint, getenv("PUBSUB_EMULATOR_HOST")),
+		ordered:         *ordered,
+		retry: retryPolicy{
+			maxAttempts:    *maxAttempts,
+			initialBackoff: *initialBackoff,
+			maxBackoff:     *maxBackoff,
+			deadline:       *publishDeadline,
+		},
+	}
+	// The summaries are written however the run ends, since dashboards need
+	// them most when something goes wrong.
+	var results []*fileResult
+	var runErr error
+	skipReason := ""
+	fail := func(code int, err error) int {
+		log.Print(err)
+		runErr = err
+		return code
+	}
+	defer func() {
+		s := newRunSummary(cfg, results)
+		s.ExitCode = code
+		s.SkipReason = skipReason
+		if runErr != nil {
+			s.Error = runErr.Error()
+		}
+		writeSummaries(cfg, *summaryJSON, *summary, s)
+	}()
+	if cfg.parallelism < 1 {
+		return fail(exitConfigError, fmt.Errorf("--parallelism must be at least 1, got %d", cfg.parallelism))
+	}
+	if cfg.retry.maxAttempts < 1 {
+		return fail(exitConfigError, fmt.Errorf("--max_attempts must be at least 1, got %d", cfg.retry.maxAttempts))
+	}
+	if err := st.load(fs, cfg); err != nil {
+		return fail(exitConfigError, err)
+	}
+	if err := cf.apply(cfg, st); err != nil {
+		return fail(exitConfigError, err)
+	}
+	if err := find.apply(cfg, st); err != nil {
+		return fail(exitConfigError, err)
+	}
+	cfg.transport = st.string("transport", *transport, st.file.Transport)
+	cfg.httpURL = st.string("http_url", *httpURL, st.file.HTTPURL)
+	cfg.presubmitHTTPURL = st.string("presubmit_http_url", *presubmitHTTPURL, st.file.PresubmitHTTPURL)
+	switch cfg.transport {
+	case transportPubSub, transportHTTP, transportFile:
+	default:
+		return fail(exitConfigError, fmt.Errorf("--transport must be %s, %s, or %s, got %q", transportPubSub, transportHTTP, transportFile, cfg.transport))
+	}
+	onEmpty := st.string("on_empty", *onEmptyFlag, st.file.OnEmpty)
+	if onEmpty != policyFail && onEmpty != policyWarn && onEmpty != policyIgnore {
+		return fail(exitConfigError, fmt.Errorf("--on_empty must be %s, %s, or %s, got %q", policyFail, policyWarn, policyIgnore, onEmpty))
+	}
+	onPublishError := st.string("on_publish_error", *onPublishErrorFlag, st.file.OnPublishError)
+	if onPublishError != policyFail && onPublishError != policyWarn {
+		return fail(exitConfigError, fmt.Errorf("--on_publish_error must be %s or %s, got %q", policyFail, policyWarn, onPublishError))
+	}
+	if *summary != "" && *summary != summaryGitHub {
+		return fail(exitConfigError, fmt.Errorf("--summary must be %s, got %q", summaryGitHub, *summary))
+	}
+	if cfg.spoolDir != "" && cfg.transport != transportPubSub {
+		return fail(exitConfigError, fmt.Errorf("--spool_dir only works with --transport=%s", transportPubSub))
+	}
+	// --out_dir is where the file transport writes to. Otherwise, it means
+	// this is a dry run.
+	if cfg.outDir != "" && cfg.transport != transportFile {
+		cfg.dryRun = true
+	}
+	if *ledgerPath != "" {
+		l, err := openLedger(*ledgerPath)
+		if err != nil {
+			return fail(exitConfigError, fmt.Errorf("could not read --ledger: %v", err))
+		}
+		cfg.ledger = l
+	}
+	if cfg.transport == transportHTTP && !cfg.dryRun {
+		secret, err := readWebhookSecret(*httpSecretFile, getenv("FLAKYBOT_HTTP_SECRET"))
+		if err != nil {
+			return fail(exitConfigError, fmt.Errorf("could not read --http_secret_file: %v", err))
+		}
+		cfg.httpSecret = secret
 	}
 	if ok := cfg.setDefaults(); !ok {
-		os.Exit(1)
+		// setDefaults logged what's missing.
+		runErr = errors.New("could not detect the required config")
+		return exitConfigError
+	}
+	if st.verbose {
+		logConfig(cfg)
+	}
+	if !cfg.shouldPublish() {
+		skipReason = fmt.Sprintf("presubmit builds are only published to --%s, or with --allow_presubmit", cfg.presubmitSetting())
+		return exitOK
 	}
 
+	cfg.buildEnd = time.Now()
+
 	log.Println("Sending logs to Flaky Bot...")
 	log.Println("See https://github.com/googleapis/repo-automation-bots/tree/main/packages/flakybot.")
 
-	logs, err := findLogs(cfg.logsDir)
+	logs, err := findLogs(cfg)
 	if err != nil {
-		log.Printf("Error searching for logs: %v", err)
-		os.Exit(1)
+		return fail(exitError, fmt.Errorf("could not search for logs: %v", err))
 	}
 	if len(logs) == 0 {
-		log.Printf("No sponge_log.xml files found in %s. Did you forget to generate sponge_log.xml?", cfg.logsDir)
-		os.Exit(1)
+		// --on_empty only changes the exit code. The summary always says
+		// why nothing was published.
+		runErr = errors.New("no test reports found")
+		return noReportsExitCode(cfg, onEmpty)
 	}
 
-	p, err := pubSubPublisher(context.Background(), cfg)
-	if err != nil {
-		log.Printf("Could not connect to Pub/Sub: %v", err)
-		os.Exit(1)
+	var p messagePublisher
+	if cfg.dryRun {
+		log.Println("Dry run: nothing will be published.")
+		if p, err = newDryRunPublisher(cfg); err != nil {
+			return fail(exitError, fmt.Errorf("could not create --out_dir: %v", err))
+		}
+	} else if p, err = newTransport(context.Background(), cfg); err != nil {
+		// Bad credentials, --http_url, or --topic won't fix themselves, so
+		// they fail the build whatever --on_publish_error says.
+		return fail(exitConfigError, err)
 	}
 
-	if err := publish(context.Background(), cfg, p, logs); err != nil {
-		log.Printf("Could not publish: %v", err)
-		os.Exit(1)
+	results, err = publish(context.Background(), cfg, p, logs)
+	if err != nil {
+		runErr = fmt.Errorf("could not publish: %v", err)
+		log.Print(runErr)
+		return publishExitCode(results, err, onPublishError)
 	}
 
 	log.Println("Done!")
+	return exitOK
 }
 
 type githubInstallation struct {
@@ -107,35 +429,280 @@ type message struct {
 	Commit       string             `json:"commit"`
 	BuildURL     string             `json:"buildURL"`
 	XUnitXML     string             `json:"xunitXML"`
+	// XUnitEncoding is how XUnitXML is encoded. See the xunitEncoding
+	// constants.
+	XUnitEncoding string `json:"xunitEncoding,omitempty"`
+	// ChunkIndex (starting at 1) and ChunkCount are set when a report was too
+	// big for one message, so it was split into several valid reports.
+	ChunkIndex int `json:"chunkIndex,omitempty"`
+	ChunkCount int `json:"chunkCount,omitempty"`
+	// ChunkGroup is the SHA-256 of the report the chunk was split from.
+	ChunkGroup string `json:"chunkGroup,omitempty"`
+	// IdempotencyKey is the same for every message of a report published for
+	// the same repo and commit, so consumers can drop replays. Chunks of a
+	// report share the key, and are told apart by ChunkIndex.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+
+	// The rest describe the build, when they're known.
+	Branch   string `json:"branch,omitempty"`
+	PRNumber int    `json:"prNumber,omitempty"`
+	JobName  string `json:"jobName,omitempty"`
+	// Trigger is continuous, presubmit, or nightly.
+	Trigger string `json:"trigger,omitempty"`
+	// Mode is presubmit or continuous. HeadSHA and BaseSHA are the head and
+	// base commits of the pull request in presubmit mode.
+	Mode    string `json:"mode,omitempty"`
+	HeadSHA string `json:"headSHA,omitempty"`
+	BaseSHA string `json:"baseSHA,omitempty"`
+	// BuildStartTime and BuildEndTime are RFC 3339 timestamps. The end time
+	// is when flakybot ran.
+	BuildStartTime string `json:"buildStartTime,omitempty"`
+	BuildEndTime   string `json:"buildEndTime,omitempty"`
+	// RunnerOS and RunnerArch are the GOOS and GOARCH flakybot ran on.
+	RunnerOS   string `json:"runnerOS,omitempty"`
+	RunnerArch string `json:"runnerArch,omitempty"`
+	// ShardIndex (starting at 0) and ShardCount are set for builds split into
+	// several parallel jobs.
+	ShardIndex *int `json:"shardIndex,omitempty"`
+	ShardCount int  `json:"shardCount,omitempty"`
+}
+
+const (
+	xunitEncodingBase64     = "base64"
+	xunitEncodingGzipBase64 = "gzip+base64"
+)
+
+// setXUnitXML encodes xml, gzipping it first if compress is true, and returns
+// the encoded length.
+func (m *message) setXUnitXML(xml []byte, compress bool) (int, error) {
+	if !compress {
+		m.XUnitXML = base64.StdEncoding.EncodeToString(xml)
+		m.XUnitEncoding = xunitEncodingBase64
+		return len(m.XUnitXML), nil
+	}
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(xml); err != nil {
+		return 0, fmt.Errorf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("gzip: %v", err)
+	}
+	m.XUnitXML = base64.StdEncoding.EncodeToString(buf.Bytes())
+	m.XUnitEncoding = xunitEncodingGzipBase64
+	return len(m.XUnitXML), nil
+}
+
+// decodeXUnitXML returns the decoded XUnitXML.
+func (m *message) decodeXUnitXML() ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(m.XUnitXML)
+	if err != nil {
+		return nil, fmt.Errorf("base64: %v", err)
+	}
+	switch m.XUnitEncoding {
+	case "", xunitEncodingBase64:
+		return data, nil
+	case xunitEncodingGzipBase64:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %v", err)
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("unknown xunitEncoding %q", m.XUnitEncoding)
+	}
 }
 
 type config struct {
-	projectID      string
-	topicID        string
+	projectID string
+	topicID   string
+	// presubmitTopic replaces topicID in presubmit mode. Presubmit results are
+	// only published to it, unless --allow_presubmit is set.
+	presubmitTopic string
 	repo           string
 	installationID string
 	commit         string
 	logsDir        string
 	serviceAccount string
 	buildURL       string
+	branch         string
+	prNumber       string
+	headSHA        string
+	baseSHA        string
+	jobName        string
+	trigger        string
+	buildStart     time.Time
+	buildEnd       time.Time
+	shardIndex     int
+	shardCount     int
+	formats        []*reportFormat
+	dryRun         bool
+	outDir         string
+	outXML         bool
+	// mode is modePresubmit or modeContinuous.
+	mode           string
+	allowPresubmit bool
+
+	maxMessageBytes int
+	compress        bool
+	parallelism     int
+	retry           retryPolicy
+	spoolDir        string
+	// pubsubEndpoint is an emulator to publish to instead of Pub/Sub.
+	pubsubEndpoint string
+	// See credentialFlags.
+	impersonateServiceAccount string
+	workloadIdentityProvider  string
+	oidcTokenFile             string
+	// ordered sets the ordering key of messages to the repo.
+	ordered bool
+	// ledger records the reports that were published, if --ledger is set.
+	ledger *ledger
+	// transport is one of the transport constants. httpURL and httpSecret
+	// are for transportHTTP.
+	transport  string
+	httpURL    string
+	httpSecret []byte
+	// presubmitHTTPURL replaces httpURL in presubmit mode, like
+	// presubmitTopic.
+	presubmitHTTPURL string
+
+	// installations looks up installation IDs with the GitHub API, if set.
+	installations *installationResolver
+	// installationTable has the known installation IDs. It defaults to
+	// defaultInstallations.
+	installationTable installationTable
+	// githubHost is the host repo is on, like github.com.
+	githubHost string
+
+	// file is the config file, and configFile its path, if there is one.
+	file       *fileConfig
+	configFile string
+	redactions []*redaction
+	// sources records where each setting came from, by flag name.
+	sources map[string]string
+
+	includes          []string
+	excludes          []string
+	maxDepth          int
+	respectGitignore  bool
+	skipUnreadable    bool
+	noDefaultExcludes bool
 }
 
-func (cfg *config) setDefaults() (ok bool) {
-	if cfg.serviceAccount == "" {
-		if gfileDir := os.Getenv("KOKORO_GFILE_DIR"); gfileDir != "" {
-			// Assume any given service account exists, but check the Trampoline
-			// account exists before trying to use it (instead of default
-			// credentials).
-			path := filepath.Join(gfileDir, "kokoro-trampoline.service-account.json")
-			if _, err := os.Stat(path); err == nil {
-				cfg.serviceAccount = path
-			}
+// detect fills in the config that wasn't set with flags from the CI
+// environment, the config file, the local git checkout, and the known
+// installation IDs, and records where each value came from. Values that can't
+// be detected are left empty.
+func (cfg *config) detect() {
+	if gfileDir := getenv("KOKORO_GFILE_DIR"); gfileDir != "" && cfg.serviceAccount == "" && cfg.workloadIdentityProvider == "" {
+		// Assume any given service account exists, but check the Trampoline
+		// account exists before trying to use it (instead of default
+		// credentials).
+		path := filepath.Join(gfileDir, "kokoro-trampoline.service-account.json")
+		if _, err := os.Stat(path); err == nil {
+			cfg.fill("service_account", &cfg.serviceAccount, sourced{path, "$KOKORO_GFILE_DIR"})
+		}
+	}
+
+	info := &buildInfo{}
+	ciName := ""
+	if ci := detectCI(getenv); ci != nil {
+		log.Printf("Detected %s.", ci.name())
+		info = ci.info(getenv)
+		ciName = ci.name()
+	}
+	file := cfg.file
+	if file == nil {
+		file = &fileConfig{}
+	}
+	cfg.fill("repo", &cfg.repo, sourced{info.repo, ciName}, sourced{file.Repo, cfg.configFile})
+	cfg.fill("commit_hash", &cfg.commit, sourced{info.commit, ciName})
+	if cfg.repo == "" || cfg.commit == "" {
+		if local, err := localGitInfo(gitStartDir); err == nil {
+			log.Printf("Using the git checkout in %s to detect the repo and commit.", gitStartDir)
+			cfg.fill("repo", &cfg.repo, sourced{local.repo, sourceGit})
+			cfg.fill("commit_hash", &cfg.commit, sourced{local.commit, sourceGit})
+			cfg.fill("branch", &cfg.branch, sourced{info.branch, ciName}, sourced{local.branch, sourceGit})
+		}
+	}
+	cfg.fill("build_url", &cfg.buildURL, sourced{info.buildURL, ciName})
+	cfg.fill("branch", &cfg.branch, sourced{info.branch, ciName})
+	cfg.fill("pr_number", &cfg.prNumber, sourced{info.prNumber, ciName})
+	cfg.fill("job_name", &cfg.jobName, sourced{info.jobName, ciName})
+	cfg.fill("trigger", &cfg.trigger, sourced{info.trigger, ciName})
+	if cfg.headSHA == "" && cfg.baseSHA == "" {
+		cfg.fill("head_sha", &cfg.headSHA, sourced{info.headSHA, ciName})
+		cfg.fill("base_sha", &cfg.baseSHA, sourced{info.baseSHA, ciName})
+	}
+	if cfg.mode == "" {
+		if cfg.trigger == triggerPresubmit || cfg.prNumber != "" {
+			cfg.fill("mode", &cfg.mode, sourced{modePresubmit, cfg.sources["trigger"]})
+		} else {
+			cfg.fill("mode", &cfg.mode, sourced{modeContinuous, sourceDefault})
+		}
+	}
+	switch {
+	case !cfg.buildStart.IsZero():
+	case !info.startTime.IsZero():
+		cfg.buildStart = info.startTime
+		cfg.setSource("build_start_time", ciName)
+	default:
+		// Most CI systems don't say when the build started, so fall back to
+		// when flakybot did.
+		cfg.buildStart = processStart
+		cfg.setSource("build_start_time", sourceDefault)
+	}
+	if cfg.shardCount == 0 && info.shardCount > 0 {
+		cfg.shardIndex, cfg.shardCount = info.shardIndex, info.shardCount
+		cfg.setSource("shard", ciName)
+	}
+	if cfg.mode == modePresubmit {
+		// Pull requests are named after their head branch, which could be
+		// anything, so they never get a branch topic.
+		if cfg.presubmitTopic != "" {
+			cfg.topicID = cfg.presubmitTopic
+			cfg.setSource("topic", cfg.sources["presubmit_topic"]+" (presubmit_topic)")
+		}
+		if cfg.presubmitHTTPURL != "" {
+			cfg.httpURL = cfg.presubmitHTTPURL
+			cfg.setSource("http_url", cfg.sources["presubmit_http_url"]+" (presubmit_http_url)")
+		}
+	} else if src := cfg.sources["topic"]; src != sourceFlag && !strings.HasPrefix(src, "$") {
+		// A topic for the branch in the config file takes precedence over
+		// the topic in the file, but not a flag or environment variable.
+		if topic := file.branchTopic(cfg.branch); topic != "" {
+			cfg.topicID = topic
+			cfg.setSource("topic", cfg.configFile+" ("+sourceBranchTopic+")")
 		}
 	}
 
+	cfg.fill("installation_id", &cfg.installationID, sourced{file.InstallationID, cfg.configFile})
 	if cfg.repo == "" {
-		cfg.repo = detectRepo()
+		return
 	}
+	if cfg.installationID == "" && cfg.installations != nil {
+		id, err := cfg.installations.resolve(context.Background(), cfg.repo)
+		if err != nil {
+			log.Printf("Unable to look up the installation ID with the GitHub API, falling back to known orgs: %v", err)
+		}
+		cfg.fill("installation_id", &cfg.installationID, sourced{id, sourceGitHubAPI})
+	}
+	if cfg.installationID == "" {
+		table := cfg.installationTable
+		if table == nil {
+			table = defaultInstallations
+		}
+		cfg.fill("installation_id", &cfg.installationID, sourced{table.lookup(cfg.githubHost, cfg.repo), sourceKnownOrgs})
+	}
+}
+
+// setDefaults detects the config that wasn't set with flags, and logs how to
+// set anything required that couldn't be detected.
+func (cfg *config) setDefaults() (ok bool) {
+	cfg.detect()
+
 	if cfg.repo == "" {
 		log.Printf(`Unable to detect repo. Please set the --repo flag.
 If your repo is github.com/GoogleCloudPlatform/golang-samples, --repo should be GoogleCloudPlatform/golang-samples.
@@ -145,9 +712,6 @@ If your repo is not in GoogleCloudPlatform or googleapis, you must also set
 		return false
 	}
 
-	if cfg.installationID == "" {
-		cfg.installationID = detectInstallationID(cfg.repo)
-	}
 	if cfg.installationID == "" {
 		log.Printf(`Unable to detect installation ID from repo=%q. Please set the --installation_id flag.
 If your repo is part of GoogleCloudPlatform or googleapis and you see this error,
@@ -158,34 +722,77 @@ See https://github.com/apps/flaky-bot/.`, cfg.repo)
 	}
 
 	if cfg.commit == "" {
-		cfg.commit = os.Getenv("KOKORO_GIT_COMMIT")
-	}
-	if cfg.commit == "" {
-		log.Printf(`Unable to detect commit hash (expected the KOKORO_GIT_COMMIT env var).
+		log.Printf(`Unable to detect commit hash from the CI environment.
 Please set --commit_hash to the latest git commit hash.
 See https://github.com/apps/flaky-bot/.`)
 		return false
 	}
 
 	if cfg.buildURL == "" {
-		buildID := os.Getenv("KOKORO_BUILD_ID")
-		if buildID == "" {
-			log.Printf(`Unable to build URL (expected the KOKORO_BUILD_ID env var).
+		log.Printf(`Unable to detect the build URL from the CI environment.
 Please set --build_url to the URL of the build.
 See https://github.com/apps/flaky-bot/.`)
-			return false
-		}
-		cfg.buildURL = fmt.Sprintf("[Build Status](https://source.cloud.google.com/results/invocations/%s), [Sponge](http://sponge2/%s)", buildID, buildID)
+		return false
 	}
 
 	return true
 }
 
+// Transports messages can be sent with.
+const (
+	transportPubSub = "pubsub"
+	transportHTTP   = "http"
+	transportFile   = "file"
+)
+
+// newTransport returns the publisher for cfg.transport. Messages that fail
+// with a transient error are retried.
+func newTransport(ctx context.Context, cfg *config) (messagePublisher, error) {
+	switch cfg.transport {
+	case transportHTTP:
+		w, err := newWebhookPublisher(cfg.httpURL, cfg.httpSecret)
+		if err != nil {
+			return nil, err
+		}
+		return newRetryPublisher(w, cfg.retry), nil
+	case transportFile:
+		p, err := newDryRunPublisher(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not create --out_dir: %v", err)
+		}
+		return p, nil
+	}
+	pub, err := pubSubPublisher(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to Pub/Sub: %v", err)
+	}
+	var p messagePublisher = newRetryPublisher(pub, cfg.retry)
+	if cfg.spoolDir != "" {
+		if p, err = newSpoolPublisher(p, cfg); err != nil {
+			return nil, fmt.Errorf("could not create --spool_dir: %v", err)
+		}
+	}
+	return p, nil
+}
+
 func pubSubPublisher(ctx context.Context, cfg *config) (*publisher, error) {
 	opts := []option.ClientOption{}
 
-	if cfg.serviceAccount != "" {
-		opts = append(opts, option.WithCredentialsFile(cfg.serviceAccount))
+	if cfg.pubsubEndpoint != "" {
+		// Emulators don't use TLS or check credentials.
+		log.Printf("Using the Pub/Sub emulator at %s.", cfg.pubsubEndpoint)
+		opts = append(opts,
+			option.WithEndpoint(cfg.pubsubEndpoint),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+	} else {
+		log.Printf("Authenticating to Pub/Sub with %s.", cfg.credentialSource())
+		creds, err := cfg.credentials()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load credentials: %v", err)
+		}
+		opts = append(opts, option.WithAuthCredentials(creds))
 	}
 
 	client, err := pubsub.NewClient(ctx, cfg.projectID, opts...)
@@ -193,69 +800,149 @@ func pubSubPublisher(ctx context.Context, cfg *config) (*publisher, error) {
 		return nil, fmt.Errorf("unable to connect to Pub/Sub: %v", err)
 	}
 	topic := client.Topic(cfg.topicID)
+	// Ordering keys are only set with --ordered.
+	topic.EnableMessageOrdering = cfg.ordered
+	if err := checkTopic(ctx, topic); err != nil {
+		return nil, err
+	}
 	return &publisher{topic: topic}, nil
 }
 
-// findLogs searches dir for sponge_log.xml files and returns their paths.
-func findLogs(dir string) ([]string, error) {
-	var paths []string
-	walk := func(path string, dirEntry fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !strings.HasSuffix(dirEntry.Name(), "sponge_log.xml") {
-			return nil
-		}
-		paths = append(paths, path)
+// checkTopic returns an error if topic doesn't exist, so a typo in --topic
+// fails before any reports are read. Publishers don't always have permission
+// to check, so that, and other errors, only skip the check.
+func checkTopic(ctx context.Context, topic *pubsub.Topic) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	ok, err := topic.Exists(ctx)
+	if status.Code(err) == codes.PermissionDenied {
 		return nil
 	}
-	if err := filepath.WalkDir(dir, walk); err != nil {
-		return nil, err
+	if err != nil {
+		log.Printf("Unable to check topic %s exists: %v", topic, err)
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("topic %s does not exist", topic)
 	}
-	return paths, nil
+	return nil
 }
 
-// publish publishes the given log files with the given publisher.
-func publish(ctx context.Context, cfg *config, p messagePublisher, logs []string) error {
-	for _, path := range logs {
-		if err := processLog(ctx, cfg, p, path); err != nil {
-			return fmt.Errorf("publishing logs: %v", err)
-		}
-	}
+// fileResult is the outcome of publishing a single log file.
+type fileResult struct {
+	path string
+	// ids are the server IDs of the messages that were published. A report
+	// split into several messages can fail after some of them are published.
+	ids []string
+	// skipped is why the file wasn't published, if it was skipped.
+	skipped string
+	// spooled are the spool files of the messages that couldn't be published
+	// and were saved for `flakybot flush` instead.
+	spooled []string
+	err     error
 
-	return nil
+	// bytes is the size of the report, after it's converted to xUnit XML,
+	// and hash is its contentHash.
+	bytes    int
+	hash     string
+	messages int
+	// counts is nil if the report couldn't be parsed.
+	counts *testCounts
 }
 
-// detectRepo tries to detect the repo from the environment.
-func detectRepo() string {
-	githubURL := os.Getenv("KOKORO_GITHUB_COMMIT_URL")
-	if githubURL == "" {
-		githubURL = os.Getenv("KOKORO_GITHUB_PULL_REQUEST_URL")
-		if githubURL != "" {
-			log.Printf("Warning! Running on a PR. Double check how you call buildocp before merging.")
-		}
+// publishError is returned by publish when some of the log files couldn't be
+// published, including ones that were only spooled.
+type publishError struct {
+	failed  []*fileResult
+	spooled []*fileResult
+	total   int
+}
+
+func (e *publishError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d files failed", len(e.failed), e.total)
+	if len(e.spooled) > 0 {
+		fmt.Fprintf(&b, " and %d were saved for `flakybot flush`", len(e.spooled))
 	}
-	if githubURL == "" {
-		return ""
+	b.WriteString(":")
+	for _, r := range e.failed {
+		fmt.Fprintf(&b, "\n  %s: %v", r.path, r.err)
 	}
-	parts := strings.Split(githubURL, "/")
-	if len(parts) < 5 {
-		return ""
+	for _, r := range e.spooled {
+		fmt.Fprintf(&b, "\n  %s: saved to %s", r.path, strings.Join(r.spooled, ", "))
 	}
-	repo := fmt.Sprintf("%s/%s", parts[3], parts[4])
-	return repo
+	return b.String()
 }
 
-// detectInstallationID tries to detect the GitHub installation ID based on the
-// repo.
-func detectInstallationID(repo string) string {
-	if strings.Contains(repo, "GoogleCloudPlatform") {
-		return "5943459"
+// publish publishes the given log files with the given publisher, up to
+// cfg.parallelism at a time. It keeps going when a file fails, and returns the
+// result of every file (in the same order as logs) along with a *publishError
+// if any of them failed or were spooled.
+func publish(ctx context.Context, cfg *config, p messagePublisher, logs []string) ([]*fileResult, error) {
+	n := cfg.parallelism
+	if n < 1 {
+		n = 1
+	}
+	results := make([]*fileResult, len(logs))
+	reports := make([][]byte, len(logs))
+	parallel(n, len(logs), func(i int) {
+		results[i], reports[i] = readLog(cfg, logs[i])
+	})
+	// Copies are found before anything is published, so the same copy is
+	// published every run, whichever report was read first.
+	skipDuplicates(results)
+	parallel(n, len(logs), func(i int) {
+		r := results[i]
+		if r.err == nil && r.skipped == "" {
+			publishLog(ctx, cfg, p, r, reports[i])
+		}
+		if r.err != nil {
+			log.Printf("Error publishing %s: %v", r.path, r.err)
+		}
+	})
+
+	var failed, spooled []*fileResult
+	skipped := 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed = append(failed, r)
+		case len(r.spooled) > 0:
+			spooled = append(spooled, r)
+		case r.skipped != "":
+			skipped++
+		}
+	}
+	summary := fmt.Sprintf("Published %d of %d files", len(logs)-len(failed)-len(spooled)-skipped, len(logs))
+	if len(spooled) > 0 {
+		summary += fmt.Sprintf(", saved %d for `flakybot flush`", len(spooled))
 	}
-	if strings.Contains(repo, "googleapis") {
-		return "6370238"
+	if skipped > 0 {
+		summary += fmt.Sprintf(", and skipped %d duplicates", skipped)
 	}
-	return ""
+	log.Print(summary + ".")
+	if len(failed) > 0 || len(spooled) > 0 {
+		return results, &publishError{failed: failed, spooled: spooled, total: len(logs)}
+	}
+	return results, nil
+}
+
+// parallel calls f with every index up to count, n at a time.
+func parallel(n, count int, f func(i int)) {
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i := range count {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}()
+	}
+	wg.Wait()
 }
 
 type messagePublisher interface {
@@ -267,16 +954,211 @@ type publisher struct {
 }
 
 func (p *publisher) publish(ctx context.Context, msg *pubsub.Message) (serverID string, err error) {
-	return p.topic.Publish(ctx, msg).Get(ctx)
+	id, err := p.topic.Publish(ctx, msg).Get(ctx)
+	if err != nil && msg.OrderingKey != "" {
+		// After a failure, the client rejects messages with the same key
+		// (including ones already in flight, with ErrPublishingPaused) until
+		// it's told to resume, so retries would fail too.
+		p.topic.ResumePublish(msg.OrderingKey)
+	}
+	return id, err
 }
 
-// processLog is used to process log files and publish them with the given publisher.
-func processLog(ctx context.Context, cfg *config, p messagePublisher, path string) error {
+// readReport reads the report at path and converts it to sponge-style xUnit
+// XML.
+func readReport(cfg *config, path string) ([]byte, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("os.ReadFile(%q): %v", path, err)
+		return nil, fmt.Errorf("os.ReadFile(%q): %v", path, err)
+	}
+	f, err := detectFormat(path, cfg.formats, len(cfg.includes) > 0)
+	if err != nil {
+		return nil, fmt.Errorf("detecting format of %q: %v", path, err)
+	}
+	if f == nil {
+		return nil, fmt.Errorf("%q is not in any of the formats %v", path, formatNames(cfg.formats))
+	}
+	data, err = f.normalize(data)
+	if err != nil {
+		return nil, fmt.Errorf("converting %q from %s: %v", path, f.name, err)
+	}
+	return redact(data, cfg.redactions), nil
+}
+
+// readLog reads the log file at path and hashes it, returning the report for
+// publishLog.
+func readLog(cfg *config, path string) (*fileResult, []byte) {
+	r := &fileResult{path: path}
+	data, err := readReport(cfg, path)
+	if err != nil {
+		r.err = err
+		return r, nil
+	}
+	r.bytes = len(data)
+	if c, err := countTests(data); err == nil {
+		r.counts = c
+	}
+	r.hash = contentHash(data)
+	return r, data
+}
+
+// publishLog publishes the report read by readLog with the given publisher,
+// and records the outcome in r. Reports in the ledger are skipped.
+func publishLog(ctx context.Context, cfg *config, p messagePublisher, r *fileResult, data []byte) {
+	path, hash := r.path, r.hash
+	key := idempotencyKey(cfg.repo, cfg.commit, hash)
+	if cfg.ledger.has(key) {
+		r.skipped = fmt.Sprintf("already published (in %s)", cfg.ledger.path)
+		log.Printf("Skipping %s: %s.", path, r.skipped)
+		return
+	}
+	msgs, err := buildMessages(cfg, data, hash)
+	if err != nil {
+		r.err = fmt.Errorf("building message for %q: %v", path, err)
+		return
+	}
+	r.messages = len(msgs)
+	attrs := messageAttributes(cfg, path, hash)
+	for _, m := range msgs {
+		m.Attributes = attrs
+		if cfg.ordered {
+			m.OrderingKey = cfg.repo
+		}
+	}
+	for i, pubsubMsg := range msgs {
+		name := path
+		if len(msgs) > 1 {
+			name = fmt.Sprintf("%s (part %d of %d)", path, i+1, len(msgs))
+		}
+		id, err := p.publish(ctx, pubsubMsg)
+		var se *spooledError
+		if errors.As(err, &se) {
+			// Keep going, so the rest of the report is spooled or published
+			// too.
+			r.spooled = append(r.spooled, se.path)
+			continue
+		}
+		if err != nil {
+			r.err = fmt.Errorf("Pub/Sub Publish.Get: %v", err)
+			return
+		}
+		r.ids = append(r.ids, id)
+		if cfg.dryRun {
+			log.Printf("Dry run: would have published %s (%v).", name, id)
+			continue
+		}
+		log.Printf("Published %s (%v)!", name, id)
+	}
+	if cfg.ledger != nil && !cfg.dryRun && len(r.spooled) == 0 {
+		// The report was published, so failing to record it isn't an error.
+		// Spooled reports are left out, since they weren't delivered yet.
+		if err := cfg.ledger.record(key, path); err != nil {
+			log.Printf("Could not record %s in %s: %v", path, cfg.ledger.path, err)
+		}
+	}
+}
+
+// formatTime formats t as an RFC 3339 timestamp in UTC, or "" if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// shouldPublish reports whether to publish the results of the build. Presubmit
+// results are only sent to --presubmit_topic or --presubmit_http_url, unless
+// --allow_presubmit is set, since the bot would treat failures on a pull
+// request like failures on the main branch. Dry runs and the file transport
+// are always allowed, since nothing reaches the bot.
+func (cfg *config) shouldPublish() bool {
+	if cfg.mode != modePresubmit || cfg.allowPresubmit || cfg.dryRun {
+		return true
+	}
+	dest := ""
+	switch cfg.transport {
+	case transportFile:
+		return true
+	case transportHTTP:
+		if cfg.presubmitHTTPURL != "" {
+			return true
+		}
+		dest = cfg.httpURL
+	default:
+		if cfg.presubmitTopic != "" {
+			return true
+		}
+		dest = fmt.Sprintf("projects/%s/topics/%s", cfg.projectID, cfg.topicID)
+	}
+	pr := ""
+	if cfg.prNumber != "" {
+		pr = fmt.Sprintf(" for PR #%s", cfg.prNumber)
+	}
+	log.Printf(`Not publishing to %s: this is a presubmit build%s.
+Flaky Bot would open issues for failures in unmerged code. To check your setup
+from a pull request, use --dry_run. To publish presubmit results separately,
+set --%s. To publish anyway, set --allow_presubmit.
+If this isn't a presubmit build, set --mode=continuous.`, dest, pr, cfg.presubmitSetting())
+	return false
+}
+
+// presubmitSetting returns the setting for where the selected transport sends
+// presubmit results.
+func (cfg *config) presubmitSetting() string {
+	if cfg.transport == transportHTTP {
+		return "presubmit_http_url"
+	}
+	return "presubmit_topic"
+}
+
+// Attributes of every message, so subscribers can filter messages without
+// decoding them.
+const (
+	attrBot            = "bot"
+	attrRepo           = "repo"
+	attrCommit         = "commit"
+	attrPath           = "path"
+	attrContentHash    = "contentHash"
+	attrSchemaVersion  = "schemaVersion"
+	attrIdempotencyKey = "idempotencyKey"
+)
+
+// schemaVersion is the version of the message JSON. Bump it for changes that
+// would break existing consumers.
+const schemaVersion = "1"
+
+// reportName is the path of a report relative to the logs dir, with forward
+// slashes.
+func reportName(logsDir, path string) string {
+	if rel, err := filepath.Rel(logsDir, path); err == nil && logsDir != "" {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// messageAttributes returns the attributes of the messages for the report at
+// path. The path is relative to the logs dir, and the content hash is the
+// SHA-256 of the report, shared by all the messages it's split into.
+func messageAttributes(cfg *config, path, hash string) map[string]string {
+	return map[string]string{
+		attrBot:            "flakybot",
+		attrRepo:           cfg.repo,
+		attrCommit:         cfg.commit,
+		attrPath:           reportName(cfg.logsDir, path),
+		attrContentHash:    hash,
+		attrSchemaVersion:  schemaVersion,
+		attrIdempotencyKey: idempotencyKey(cfg.repo, cfg.commit, hash),
+	}
+}
+
+// buildMessages returns the messages to publish for the given xUnit XML, whose
+// contentHash is hash. If the XML is too big for a single message, it's split
+// into several valid reports, each in its own message.
+func buildMessages(cfg *config, xml []byte, hash string) ([]*pubsub.Message, error) {
+	maxBytes := cfg.maxMessageBytes
+	if maxBytes == 0 {
+		maxBytes = defaultMaxMessageBytes
 	}
-	enc := base64.StdEncoding.EncodeToString(data)
 	msg := message{
 		Name:         "flakybot",
 		Type:         "function",
@@ -285,19 +1167,83 @@ func processLog(ctx context.Context, cfg *config, p messagePublisher, path strin
 		Repo:         cfg.repo,
 		Commit:       cfg.commit,
 		BuildURL:     cfg.buildURL,
-		XUnitXML:     enc,
+
+		IdempotencyKey: idempotencyKey(cfg.repo, cfg.commit, hash),
+
+		Branch:         cfg.branch,
+		JobName:        cfg.jobName,
+		Trigger:        cfg.trigger,
+		Mode:           cfg.mode,
+		HeadSHA:        cfg.headSHA,
+		BaseSHA:        cfg.baseSHA,
+		BuildStartTime: formatTime(cfg.buildStart),
+		BuildEndTime:   formatTime(cfg.buildEnd),
+		RunnerOS:       runtime.GOOS,
+		RunnerArch:     runtime.GOARCH,
+	}
+	if n, err := strconv.Atoi(cfg.prNumber); err == nil && n > 0 {
+		msg.PRNumber = n
+	}
+	if cfg.shardCount > 0 {
+		msg.ShardIndex = &cfg.shardIndex
+		msg.ShardCount = cfg.shardCount
 	}
-	data, err = json.Marshal(msg)
+	enc, err := msg.setXUnitXML(xml, cfg.compress)
 	if err != nil {
-		return fmt.Errorf("json.Marshal: %v", err)
+		return nil, err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %v", err)
 	}
-	pubsubMsg := &pubsub.Message{
-		Data: data,
+	if len(data) <= maxBytes {
+		return []*pubsub.Message{{Data: data}}, nil
 	}
-	id, err := p.publish(ctx, pubsubMsg)
+
+	// Work out how much XML fits in a message once it's encoded.
+	msg.ChunkGroup = hash
+	msg.ChunkIndex, msg.ChunkCount = math.MaxInt32, math.MaxInt32
+	msg.XUnitXML = ""
+	envelope, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("Pub/Sub Publish.Get: %v", err)
+		return nil, fmt.Errorf("json.Marshal: %v", err)
 	}
-	log.Printf("Published %s (%v)!", path, id)
-	return nil
+	maxXML := (maxBytes - len(envelope)) / 4 * 3
+	if cfg.compress {
+		// Assume every part compresses as well as the whole report, then check.
+		maxXML = int(float64(maxXML) * float64(len(xml)) / float64(enc) * 0.9)
+	}
+	for maxXML > 0 {
+		parts, err := splitReport(xml, maxXML)
+		if err != nil {
+			hint := ""
+			if !cfg.compress {
+				hint = " (try --compress)"
+			}
+			return nil, fmt.Errorf("message is %d bytes, more than the maximum of %d, and splitting it failed%s: %v", len(data), maxBytes, hint, err)
+		}
+		var msgs []*pubsub.Message
+		for i, part := range parts {
+			msg.ChunkIndex = i + 1
+			msg.ChunkCount = len(parts)
+			if _, err := msg.setXUnitXML(part, cfg.compress); err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return nil, fmt.Errorf("json.Marshal: %v", err)
+			}
+			if len(data) > maxBytes {
+				// A part compressed worse than expected. Try smaller parts.
+				msgs = nil
+				break
+			}
+			msgs = append(msgs, &pubsub.Message{Data: data})
+		}
+		if msgs != nil {
+			return msgs, nil
+		}
+		maxXML /= 2
+	}
+	return nil, fmt.Errorf("message is %d bytes, more than the maximum of %d, and it can't be split small enough", len(data), maxBytes)
 }
@@ -19,15 +19,21 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestSetDefaults(t *testing.T) {
@@ -52,6 +58,9 @@ func TestSetDefaults(t *testing.T) {
 				installationID: "5943459",
 				commit:         "abc123",
 				buildURL:       "google.com",
+				trigger:        triggerContinuous,
+				mode:           modeContinuous,
+				buildStart:     processStart,
 			},
 			wantOK: true,
 		},
@@ -70,6 +79,8 @@ func TestSetDefaults(t *testing.T) {
 				installationID: "5943459",
 				commit:         "abc123",
 				buildURL:       "google.com",
+				mode:           modeContinuous,
+				buildStart:     processStart,
 			},
 			wantOK: true,
 		},
@@ -88,6 +99,8 @@ func TestSetDefaults(t *testing.T) {
 				installationID: "5943459",
 				commit:         "abc123",
 				buildURL:       "[Build Status](https://source.cloud.google.com/results/invocations/test), [Sponge](http://sponge2/test)",
+				mode:           modeContinuous,
+				buildStart:     processStart,
 			},
 			wantOK: true,
 		},
@@ -111,10 +124,10 @@ func TestSetDefaults(t *testing.T) {
 			buf := &bytes.Buffer{}
 			log.SetOutput(buf)
 			defer log.SetOutput(os.Stderr)
-			for k, v := range test.env {
-				os.Setenv(k, v)
-				defer os.Unsetenv(k)
-			}
+			getenv = func(k string) string { return test.env[k] }
+			defer func() { getenv = os.Getenv }()
+			gitStartDir = t.TempDir()
+			defer func() { gitStartDir = "." }()
 			cfg := test.in
 			if ok := cfg.setDefaults(); ok != test.wantOK {
 				t.Fatalf("setDefaults got ok=%v, want ok=%v:\n%v", ok, test.wantOK, buf.String())
@@ -122,7 +135,7 @@ func TestSetDefaults(t *testing.T) {
 			if !test.wantOK {
 				return
 			}
-			if diff := cmp.Diff(cfg, test.want, cmp.AllowUnexported(config{})); diff != "" {
+			if diff := cmp.Diff(cfg, test.want, cmp.AllowUnexported(config{}, retryPolicy{}), cmpopts.IgnoreFields(config{}, "sources")); diff != "" {
 				t.Errorf("newConfig got %+v, want %+v. Diff (+want, -got):\n%s", cfg, test.want, diff)
 			}
 		})
@@ -160,11 +173,10 @@ func TestDetectRepo(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		os.Setenv(test.envVar, test.envValue)
-		if got := detectRepo(); got != test.want {
-			t.Errorf("detectRepo(%s=%s) = %q, want %q", test.envVar, test.envValue, got, test.want)
+		env := fakeEnv{test.envVar: test.envValue}
+		if got := (kokoro{}).info(env.get).repo; got != test.want {
+			t.Errorf("kokoro repo(%s=%s) = %q, want %q", test.envVar, test.envValue, got, test.want)
 		}
-		os.Unsetenv(test.envVar)
 	}
 }
 
@@ -181,6 +193,16 @@ func TestDetectInstallationID(t *testing.T) {
 			repo: "googleapis/google-cloud-go",
 			want: "6370238",
 		},
+		{
+			repo: "GoogleAPIs/python-storage",
+			want: "6370238",
+		},
+		{
+			repo: "someone/googleapis",
+		},
+		{
+			repo: "googleapis-fork/google-cloud-go",
+		},
 		{
 			repo: "unknown",
 		},
@@ -190,8 +212,8 @@ func TestDetectInstallationID(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		if got := detectInstallationID(test.repo); got != test.want {
-			t.Errorf("detectInstallationID(%q) = %q, want %q", test.repo, got, test.want)
+		if got := defaultInstallations.lookup("", test.repo); got != test.want {
+			t.Errorf("defaultInstallations.lookup(%q) = %q, want %q", test.repo, got, test.want)
 		}
 	}
 }
@@ -221,12 +243,39 @@ func TestJSONMarshalMessage(t *testing.T) {
 }
 
 type fakePublisher struct {
-	called []string
+	// fail, if set, is called for every message and publishing fails if it
+	// returns true.
+	fail func(*pubsub.Message) bool
+	// errs are returned by the first calls to publish, one per call.
+	errs []error
+	// err is the error publishing fails with if fail returns true. It
+	// defaults to a permanent error.
+	err error
+
+	mu       sync.Mutex
+	attempts int
+	called   []string
 }
 
 func (p *fakePublisher) publish(_ context.Context, msg *pubsub.Message) (serverID string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		if err != nil {
+			return "", err
+		}
+	}
+	if p.fail != nil && p.fail(msg) {
+		if p.err != nil {
+			return "", p.err
+		}
+		return "", errors.New("fake publish error")
+	}
 	p.called = append(p.called, string(msg.Data))
-	return "", nil
+	return fmt.Sprintf("id-%d", len(p.called)), nil
 }
 
 func TestFindAndPublish(t *testing.T) {
@@ -238,10 +287,11 @@ func TestFindAndPublish(t *testing.T) {
 		t.Fatalf("os.MkdirTemp: %v", err)
 	}
 
-	content := []byte("unused")
-	wantEnc := base64.StdEncoding.EncodeToString(content)
-
+	// Identical reports are only published once, so each file is different.
+	var wantEnc []string
 	for _, f := range filesToCreate {
+		content := []byte("unused " + f)
+		wantEnc = append(wantEnc, base64.StdEncoding.EncodeToString(content))
 		f = filepath.Join(tmpdir, f)
 		dir := filepath.Dir(f)
 		if err := os.MkdirAll(dir, 0777); err != nil && err != os.ErrExist {
@@ -258,9 +308,10 @@ func TestFindAndPublish(t *testing.T) {
 		commit:         "abc123",
 		buildURL:       "https://google.com",
 		logsDir:        tmpdir,
+		formats:        []*reportFormat{formatByName("sponge")},
 	}
 
-	logs, err := findLogs(cfg.logsDir)
+	logs, err := findLogs(cfg)
 	if err != nil {
 		t.Fatalf("Error finding logs in %q: %v", cfg.logsDir, err)
 	}
@@ -270,16 +321,355 @@ func TestFindAndPublish(t *testing.T) {
 	}
 
 	p := &fakePublisher{}
-	if err := publish(context.Background(), cfg, p, logs); err != nil {
+	if _, err := publish(context.Background(), cfg, p, logs); err != nil {
 		t.Fatalf("Error publishing logs: %v", err)
 	}
 
 	if got := len(p.called); got != numLogFiles {
 		t.Errorf("publish called %d times, want %d", got, numLogFiles)
 	}
-	for _, got := range p.called {
-		if !strings.Contains(got, wantEnc) {
-			t.Errorf("published message %v, want to contain %q", got, wantEnc)
+	published := strings.Join(p.called, "\n")
+	for _, enc := range wantEnc[:numLogFiles] {
+		if !strings.Contains(published, enc) {
+			t.Errorf("published messages %v, want one to contain %q", p.called, enc)
+		}
+	}
+}
+
+func TestPublishContinuesAfterFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tmpdir := t.TempDir()
+	var logs []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(tmpdir, fmt.Sprintf("%d", i), "sponge_log.xml")
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("report %d", i)), 0644); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+		logs = append(logs, path)
+	}
+	// A missing file fails before publishing.
+	logs = append(logs, filepath.Join(tmpdir, "missing", "sponge_log.xml"))
+	badXML := base64.StdEncoding.EncodeToString([]byte("report 7"))
+
+	cfg := &config{
+		installationID: "123",
+		repo:           "googleapis/repo-automation-bots",
+		commit:         "abc123",
+		buildURL:       "https://example.com",
+		formats:        []*reportFormat{formatByName("sponge")},
+		parallelism:    4,
+	}
+	p := &fakePublisher{fail: func(m *pubsub.Message) bool {
+		return strings.Contains(string(m.Data), badXML)
+	}}
+	results, err := publish(context.Background(), cfg, p, logs)
+	pErr, ok := err.(*publishError)
+	if !ok {
+		t.Fatalf("publish got error %v, want a *publishError", err)
+	}
+	if got := len(p.called); got != 19 {
+		t.Errorf("publish published %d messages, want 19", got)
+	}
+	if got, want := len(results), len(logs); got != want {
+		t.Fatalf("publish returned %d results, want %d", got, want)
+	}
+	var failed []string
+	for i, r := range results {
+		if r.path != logs[i] {
+			t.Errorf("results[%d] is for %q, want %q", i, r.path, logs[i])
+		}
+		if r.err != nil {
+			failed = append(failed, r.path)
+			continue
+		}
+		if len(r.ids) != 1 {
+			t.Errorf("results[%d] has %d IDs, want 1", i, len(r.ids))
 		}
 	}
+	want := []string{logs[7], logs[20]}
+	if diff := cmp.Diff(failed, want); diff != "" {
+		t.Errorf("publish failed files diff (-got, +want):\n%s", diff)
+	}
+	if len(pErr.failed) != 2 || pErr.total != len(logs) {
+		t.Errorf("publish got error %v, want 2 of %d files failed", err, len(logs))
+	}
+}
+
+func TestRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"help"}, want: exitOK},
+		{args: []string{"help", "publish"}, want: exitOK},
+		{args: []string{"validate", "-h"}, want: exitOK},
+		{args: []string{"not-a-command"}, want: exitConfigError},
+		{args: []string{"publish", "-not_a_flag"}, want: exitConfigError},
+		// Flags without a command go to publish.
+		{args: []string{"-not_a_flag"}, want: exitConfigError},
+		{args: []string{"-mode=sometimes"}, want: exitConfigError},
+		{args: []string{"env", "-mode=sometimes"}, want: exitConfigError},
+	}
+	for _, test := range tests {
+		if got := run(test.args); got != test.want {
+			t.Errorf("run(%q) = %d, want %d", test.args, got, test.want)
+		}
+	}
+}
+
+func TestSetDefaultsMode(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name string
+		env  fakeEnv
+		in   *config
+		want string
+	}{
+		{
+			name: "continuous",
+			env:  fakeEnv{"KOKORO_GITHUB_COMMIT_URL": "https://github.com/googleapis/google-cloud-go/commit/abc123"},
+			in:   &config{},
+			want: modeContinuous,
+		},
+		{
+			name: "nightly",
+			env:  fakeEnv{"GITHUB_ACTIONS": "true", "GITHUB_EVENT_NAME": "schedule"},
+			in:   &config{},
+			want: modeContinuous,
+		},
+		{
+			name: "pull request",
+			env:  fakeEnv{"KOKORO_GITHUB_PULL_REQUEST_URL": "https://github.com/googleapis/google-cloud-go/pull/42"},
+			in:   &config{},
+			want: modePresubmit,
+		},
+		{
+			name: "flag wins",
+			env:  fakeEnv{"KOKORO_GITHUB_PULL_REQUEST_URL": "https://github.com/googleapis/google-cloud-go/pull/42"},
+			in:   &config{mode: modeContinuous},
+			want: modeContinuous,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getenv = test.env.get
+			defer func() { getenv = os.Getenv }()
+			gitStartDir = t.TempDir()
+			defer func() { gitStartDir = "." }()
+			cfg := test.in
+			cfg.repo, cfg.commit, cfg.buildURL = "googleapis/google-cloud-go", "abc123", "local"
+			if ok := cfg.setDefaults(); !ok {
+				t.Fatalf("setDefaults got ok=false, want true")
+			}
+			if cfg.mode != test.want {
+				t.Errorf("setDefaults got mode %q, want %q", cfg.mode, test.want)
+			}
+		})
+	}
+}
+
+func TestShouldPublish(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		cfg  *config
+		want bool
+	}{
+		{cfg: &config{mode: modeContinuous, topicID: defaultTopic}, want: true},
+		{cfg: &config{mode: modePresubmit, topicID: defaultTopic}, want: false},
+		{cfg: &config{mode: modePresubmit, topicID: defaultTopic, allowPresubmit: true}, want: true},
+		{cfg: &config{mode: modePresubmit, topicID: defaultTopic, dryRun: true}, want: true},
+		// Any topic other than --presubmit_topic is off limits.
+		{cfg: &config{mode: modePresubmit, topicID: "flaky-tests"}, want: false},
+		{cfg: &config{mode: modePresubmit, topicID: "presubmits", presubmitTopic: "presubmits"}, want: true},
+		// Other transports are gated on their own destination.
+		{cfg: &config{mode: modePresubmit, transport: transportHTTP, httpURL: "https://example.com"}, want: false},
+		{cfg: &config{mode: modePresubmit, transport: transportHTTP, httpURL: "https://example.com", presubmitTopic: "presubmits"}, want: false},
+		{cfg: &config{mode: modePresubmit, transport: transportHTTP, httpURL: "https://example.com/pr", presubmitHTTPURL: "https://example.com/pr"}, want: true},
+		{cfg: &config{mode: modePresubmit, transport: transportHTTP, httpURL: "https://example.com", allowPresubmit: true}, want: true},
+		{cfg: &config{mode: modePresubmit, transport: transportFile}, want: true},
+	}
+	for _, test := range tests {
+		if got := test.cfg.shouldPublish(); got != test.want {
+			t.Errorf("shouldPublish(mode=%s, transport=%s, topic=%s, presubmitTopic=%s, presubmitHTTPURL=%s, allowPresubmit=%v, dryRun=%v) = %v, want %v", test.cfg.mode, test.cfg.transport, test.cfg.topicID, test.cfg.presubmitTopic, test.cfg.presubmitHTTPURL, test.cfg.allowPresubmit, test.cfg.dryRun, got, test.want)
+		}
+	}
+}
+
+func TestMessageAttributesPath(t *testing.T) {
+	tests := []struct {
+		logsDir string
+		path    string
+		want    string
+	}{
+		{logsDir: "", path: "sponge_log.xml", want: "sponge_log.xml"},
+		{logsDir: "logs", path: filepath.Join("logs", "a", "b", "sponge_log.xml"), want: "a/b/sponge_log.xml"},
+		{logsDir: ".", path: filepath.Join("a", "sponge_log.xml"), want: "a/sponge_log.xml"},
+	}
+	for _, test := range tests {
+		cfg := &config{logsDir: test.logsDir, repo: "foo/bar", commit: "abc123"}
+		if got := messageAttributes(cfg, test.path, "")[attrPath]; got != test.want {
+			t.Errorf("messageAttributes(logsDir=%q, %q) path = %q, want %q", test.logsDir, test.path, got, test.want)
+		}
+	}
+}
+
+func TestBuildMessagesMetadata(t *testing.T) {
+	cfg := &config{
+		installationID: "123",
+		repo:           "googleapis/repo-automation-bots",
+		commit:         "abc123",
+		buildURL:       "https://example.com",
+		branch:         "main",
+		prNumber:       "42",
+		jobName:        "ci / test",
+		trigger:        triggerPresubmit,
+		mode:           modePresubmit,
+		headSHA:        "def456",
+		baseSHA:        "789abc",
+		buildStart:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		buildEnd:       time.Date(2026, 1, 2, 4, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+		shardIndex:     0,
+		shardCount:     2,
+	}
+	empty := []byte("<testsuites></testsuites>")
+	msgs, err := buildMessages(cfg, empty, contentHash(empty))
+	if err != nil {
+		t.Fatalf("buildMessages: %v", err)
+	}
+	got := map[string]any{}
+	if err := json.Unmarshal(msgs[0].Data, &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	want := map[string]any{
+		"branch":         "main",
+		"prNumber":       42.0,
+		"jobName":        "ci / test",
+		"trigger":        "presubmit",
+		"mode":           "presubmit",
+		"headSHA":        "def456",
+		"baseSHA":        "789abc",
+		"buildStartTime": "2026-01-02T03:04:05Z",
+		"buildEndTime":   "2026-01-02T09:00:00Z",
+		"runnerOS":       runtime.GOOS,
+		"runnerArch":     runtime.GOARCH,
+		"shardIndex":     0.0,
+		"shardCount":     2.0,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("buildMessages got %s=%v, want %v", k, got[k], v)
+		}
+	}
+
+	// Unknown values are left out, so older consumers see the same message.
+	cfg = &config{repo: "googleapis/repo-automation-bots", prNumber: "not a number"}
+	msgs, err = buildMessages(cfg, empty, contentHash(empty))
+	if err != nil {
+		t.Fatalf("buildMessages: %v", err)
+	}
+	got = map[string]any{}
+	if err := json.Unmarshal(msgs[0].Data, &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	for _, k := range []string{"branch", "prNumber", "jobName", "trigger", "mode", "headSHA", "baseSHA", "buildStartTime", "buildEndTime", "shardIndex", "shardCount"} {
+		if v, ok := got[k]; ok {
+			t.Errorf("buildMessages got %s=%v, want it left out", k, v)
+		}
+	}
+}
+
+func TestBuildMessagesCompress(t *testing.T) {
+	xml, err := os.ReadFile("test/fixtures/testdata/node_group.xml")
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	for _, compress := range []bool{false, true} {
+		cfg := &config{
+			installationID: "123",
+			repo:           "googleapis/repo-automation-bots",
+			commit:         "abc123",
+			buildURL:       "https://example.com",
+			compress:       compress,
+		}
+		msgs, err := buildMessages(cfg, xml, contentHash(xml))
+		if err != nil {
+			t.Fatalf("buildMessages(compress=%v): %v", compress, err)
+		}
+		if len(msgs) != 1 {
+			t.Fatalf("buildMessages(compress=%v) got %d messages, want 1", compress, len(msgs))
+		}
+		msg := &message{}
+		if err := json.Unmarshal(msgs[0].Data, msg); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		wantEncoding := xunitEncodingBase64
+		if compress {
+			wantEncoding = xunitEncodingGzipBase64
+			if len(msg.XUnitXML) >= len(xml) {
+				t.Errorf("compressed xunitXML is %d bytes, want less than %d", len(msg.XUnitXML), len(xml))
+			}
+		}
+		if msg.XUnitEncoding != wantEncoding {
+			t.Errorf("buildMessages(compress=%v) got xunitEncoding %q, want %q", compress, msg.XUnitEncoding, wantEncoding)
+		}
+		got, err := msg.decodeXUnitXML()
+		if err != nil {
+			t.Fatalf("decodeXUnitXML: %v", err)
+		}
+		if !bytes.Equal(got, xml) {
+			t.Errorf("decodeXUnitXML(compress=%v) didn't round trip", compress)
+		}
+
+	}
+}
+
+func TestBuildMessagesCompressSplits(t *testing.T) {
+	xml := manySuites(200, 10)
+
+	cfg := &config{repo: "googleapis/repo-automation-bots", compress: true}
+	msgs, err := buildMessages(cfg, xml, contentHash(xml))
+	if err != nil {
+		t.Fatalf("buildMessages: %v", err)
+	}
+	cfg.maxMessageBytes = len(msgs[0].Data) / 3
+	msgs, err = buildMessages(cfg, xml, contentHash(xml))
+	if err != nil {
+		t.Fatalf("buildMessages(maxMessageBytes=%d): %v", cfg.maxMessageBytes, err)
+	}
+	if len(msgs) < 3 {
+		t.Errorf("buildMessages(maxMessageBytes=%d) got %d messages, want at least 3", cfg.maxMessageBytes, len(msgs))
+	}
+	var cases int
+	for _, m := range msgs {
+		if len(m.Data) > cfg.maxMessageBytes {
+			t.Errorf("buildMessages got a %d byte message, want at most %d", len(m.Data), cfg.maxMessageBytes)
+		}
+		msg := &message{}
+		if err := json.Unmarshal(m.Data, msg); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		if msg.XUnitEncoding != xunitEncodingGzipBase64 {
+			t.Errorf("buildMessages got xunitEncoding %q, want %q", msg.XUnitEncoding, xunitEncodingGzipBase64)
+		}
+		part, err := msg.decodeXUnitXML()
+		if err != nil {
+			t.Fatalf("decodeXUnitXML: %v", err)
+		}
+		cases += bytes.Count(part, []byte("<testcase "))
+	}
+	if cases != 2000 {
+		t.Errorf("buildMessages parts have %d test cases, want 2000", cases)
+	}
 }
@@ -0,0 +1,431 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+// reportFormat is a test report format flakybot knows how to find and
+// convert to the sponge-style xUnit XML the bot expects.
+type reportFormat struct {
+	// name is used to enable the format with the -formats flag.
+	name string
+	// patterns are filepath.Match patterns for the base names of reports in
+	// this format.
+	patterns []string
+	// roots are the names of the root XML element of reports in this format.
+	// If there are none, the file name alone identifies the format.
+	roots []string
+	// normalize converts a report to sponge-style xUnit XML.
+	normalize func(data []byte) ([]byte, error)
+}
+
+// reportFormats are the supported formats, in the order they're checked.
+var reportFormats = []*reportFormat{
+	{
+		name:      "sponge",
+		patterns:  []string{"*sponge_log.xml"},
+		normalize: passthrough,
+	},
+	{
+		// pytest --junitxml, Maven Surefire TEST-*.xml, go-junit-report, etc.
+		// They are already in the format the bot expects.
+		name:      "junit",
+		patterns:  []string{"*.xml"},
+		roots:     []string{"testsuites", "testsuite"},
+		normalize: passthrough,
+	},
+	{
+		name:      "nunit",
+		patterns:  []string{"*.xml"},
+		roots:     []string{"test-run"},
+		normalize: normalizeNUnit,
+	},
+	{
+		name:      "xunit",
+		patterns:  []string{"*.xml"},
+		roots:     []string{"assemblies"},
+		normalize: normalizeXUnitNet,
+	},
+	{
+		name:      "trx",
+		patterns:  []string{"*.trx"},
+		roots:     []string{"TestRun"},
+		normalize: normalizeTRX,
+	},
+}
+
+// parseFormats parses a comma separated list of format names.
+func parseFormats(s string) ([]*reportFormat, error) {
+	var formats []*reportFormat
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f := formatByName(name)
+		if f == nil {
+			return nil, fmt.Errorf("unknown report format %q, want one of %s", name, strings.Join(formatNames(reportFormats), ","))
+		}
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no report formats given")
+	}
+	return formats, nil
+}
+
+func formatByName(name string) *reportFormat {
+	for _, f := range reportFormats {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func formatNames(formats []*reportFormat) []string {
+	var names []string
+	for _, f := range formats {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// matchName reports whether base matches one of the format's patterns.
+func (f *reportFormat) matchName(base string) bool {
+	for _, p := range f.patterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// detectFormat returns the first of formats that path is in, or nil if there
+// isn't one. The file is only read if a format needs its root element.
+//
+// If anyName is true, the file name doesn't need to match the format's
+// patterns. In that case, formats identified by their root element are
+// checked first, so an explicitly included NUnit file isn't mistaken for a
+// sponge_log.xml.
+func detectFormat(path string, formats []*reportFormat, anyName bool) (*reportFormat, error) {
+	base := filepath.Base(path)
+	var root string
+	rootRead := false
+	for _, f := range formats {
+		if !anyName && !f.matchName(base) {
+			continue
+		}
+		if len(f.roots) == 0 {
+			if anyName {
+				continue
+			}
+			return f, nil
+		}
+		if !rootRead {
+			var err error
+			root, err = readRoot(path)
+			if err != nil {
+				return nil, err
+			}
+			rootRead = true
+		}
+		for _, r := range f.roots {
+			if r == root {
+				return f, nil
+			}
+		}
+	}
+	if anyName {
+		for _, f := range formats {
+			if len(f.roots) == 0 {
+				return f, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// readRoot returns the local name of the root XML element in path. It returns
+// an empty string if the file isn't XML.
+func readRoot(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	d := xml.NewDecoder(f)
+	d.CharsetReader = passthroughCharset
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			// Not XML (or empty), so it's not in any format with a root.
+			return "", nil
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func passthrough(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// spongeBuilder groups test cases into one suite per class, in the order the
+// classes are first seen.
+type spongeBuilder struct {
+	suites  []*xunit.TestSuite
+	byClass map[string]*xunit.TestSuite
+}
+
+func (b *spongeBuilder) add(tc *xunit.TestCase) {
+	if b.byClass == nil {
+		b.byClass = map[string]*xunit.TestSuite{}
+	}
+	s := b.byClass[tc.ClassName]
+	if s == nil {
+		s = &xunit.TestSuite{Name: tc.ClassName}
+		b.byClass[tc.ClassName] = s
+		b.suites = append(b.suites, s)
+	}
+	s.Tests++
+	switch {
+	case tc.Failure != nil:
+		s.Failures++
+	case tc.Error != nil:
+		s.Errors++
+	case tc.Skipped != nil:
+		s.Skipped++
+	}
+	s.Cases = append(s.Cases, tc)
+}
+
+func (b *spongeBuilder) marshal() ([]byte, error) {
+	out, err := xml.MarshalIndent(&xunit.TestSuites{Suites: b.suites}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// unmarshalReport is xml.Unmarshal, but allows non-UTF-8 encoding
+// declarations, which .NET tools like to write.
+func unmarshalReport(data []byte, v any) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = passthroughCharset
+	return d.Decode(v)
+}
+
+// passthroughCharset is an xml.Decoder CharsetReader that assumes the file
+// really is UTF-8, no matter what the declaration says.
+func passthroughCharset(_ string, r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+type nunitTestRun struct {
+	Suites []*nunitTestSuite `xml:"test-suite"`
+}
+
+type nunitTestSuite struct {
+	Type     string            `xml:"type,attr"`
+	FullName string            `xml:"fullname,attr"`
+	Suites   []*nunitTestSuite `xml:"test-suite"`
+	Cases    []*nunitTestCase  `xml:"test-case"`
+}
+
+type nunitTestCase struct {
+	Name      string `xml:"name,attr"`
+	ClassName string `xml:"classname,attr"`
+	Result    string `xml:"result,attr"`
+	Label     string `xml:"label,attr"`
+	Duration  string `xml:"duration,attr"`
+	Failure   struct {
+		Message    string `xml:"message"`
+		StackTrace string `xml:"stack-trace"`
+	} `xml:"failure"`
+	Reason struct {
+		Message string `xml:"message"`
+	} `xml:"reason"`
+}
+
+// normalizeNUnit converts an NUnit 3 test-run report.
+func normalizeNUnit(data []byte) ([]byte, error) {
+	run := &nunitTestRun{}
+	if err := unmarshalReport(data, run); err != nil {
+		return nil, fmt.Errorf("parsing NUnit report: %v", err)
+	}
+	b := &spongeBuilder{}
+	var walk func(s *nunitTestSuite)
+	walk = func(s *nunitTestSuite) {
+		for _, c := range s.Cases {
+			class := c.ClassName
+			if class == "" {
+				class = s.FullName
+			}
+			tc := &xunit.TestCase{ClassName: class, Name: c.Name, Time: c.Duration}
+			switch c.Result {
+			case "Passed":
+			case "Failed":
+				r := &xunit.Result{Message: c.Failure.Message, Text: c.Failure.StackTrace}
+				if c.Label == "Error" {
+					tc.Error = r
+				} else {
+					tc.Failure = r
+				}
+			default: // Skipped, Inconclusive, etc.
+				tc.Skipped = &xunit.Result{Message: c.Reason.Message}
+			}
+			b.add(tc)
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	for _, s := range run.Suites {
+		walk(s)
+	}
+	return b.marshal()
+}
+
+type xunitNetAssemblies struct {
+	Assemblies []struct {
+		Collections []struct {
+			Tests []*xunitNetTest `xml:"test"`
+		} `xml:"collection"`
+	} `xml:"assembly"`
+}
+
+type xunitNetTest struct {
+	Name    string `xml:"name,attr"`
+	Type    string `xml:"type,attr"`
+	Method  string `xml:"method,attr"`
+	Time    string `xml:"time,attr"`
+	Result  string `xml:"result,attr"`
+	Failure struct {
+		ExceptionType string `xml:"exception-type,attr"`
+		Message       string `xml:"message"`
+		StackTrace    string `xml:"stack-trace"`
+	} `xml:"failure"`
+	Reason string `xml:"reason"`
+}
+
+// normalizeXUnitNet converts an xUnit.net v2 assemblies report.
+func normalizeXUnitNet(data []byte) ([]byte, error) {
+	assemblies := &xunitNetAssemblies{}
+	if err := unmarshalReport(data, assemblies); err != nil {
+		return nil, fmt.Errorf("parsing xUnit.net report: %v", err)
+	}
+	b := &spongeBuilder{}
+	for _, a := range assemblies.Assemblies {
+		for _, c := range a.Collections {
+			for _, t := range c.Tests {
+				name := t.Method
+				if name == "" {
+					name = strings.TrimPrefix(t.Name, t.Type+".")
+				}
+				tc := &xunit.TestCase{ClassName: t.Type, Name: name, Time: t.Time}
+				switch t.Result {
+				case "Pass":
+				case "Fail":
+					tc.Failure = &xunit.Result{
+						Message: t.Failure.Message,
+						Type:    t.Failure.ExceptionType,
+						Text:    t.Failure.StackTrace,
+					}
+				default: // Skip, NotRun.
+					tc.Skipped = &xunit.Result{Message: t.Reason}
+				}
+				b.add(tc)
+			}
+		}
+	}
+	return b.marshal()
+}
+
+type trxTestRun struct {
+	Results []*trxResult `xml:"Results>UnitTestResult"`
+	Tests   []struct {
+		ID     string `xml:"id,attr"`
+		Method struct {
+			ClassName string `xml:"className,attr"`
+			Name      string `xml:"name,attr"`
+		} `xml:"TestMethod"`
+	} `xml:"TestDefinitions>UnitTest"`
+}
+
+type trxResult struct {
+	TestID   string `xml:"testId,attr"`
+	TestName string `xml:"testName,attr"`
+	Outcome  string `xml:"outcome,attr"`
+	Duration string `xml:"duration,attr"`
+	Message  string `xml:"Output>ErrorInfo>Message"`
+	Stack    string `xml:"Output>ErrorInfo>StackTrace"`
+}
+
+// normalizeTRX converts a Visual Studio TRX report.
+func normalizeTRX(data []byte) ([]byte, error) {
+	run := &trxTestRun{}
+	if err := unmarshalReport(data, run); err != nil {
+		return nil, fmt.Errorf("parsing TRX report: %v", err)
+	}
+	classes := map[string]string{}
+	for _, t := range run.Tests {
+		classes[t.ID] = t.Method.ClassName
+	}
+	b := &spongeBuilder{}
+	for _, r := range run.Results {
+		class := classes[r.TestID]
+		name := strings.TrimPrefix(r.TestName, class+".")
+		tc := &xunit.TestCase{ClassName: class, Name: name, Time: trxSeconds(r.Duration)}
+		switch r.Outcome {
+		case "Passed":
+		case "Failed", "Error", "Timeout", "Aborted":
+			tc.Failure = &xunit.Result{Message: r.Message, Text: r.Stack}
+		default: // NotExecuted, Inconclusive, etc.
+			tc.Skipped = &xunit.Result{Message: r.Message}
+		}
+		b.add(tc)
+	}
+	return b.marshal()
+}
+
+// trxSeconds converts a TRX duration (hh:mm:ss.fffffff) to seconds.
+func trxSeconds(d string) string {
+	parts := strings.Split(d, ":")
+	if len(parts) != 3 {
+		return ""
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	s, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return ""
+	}
+	total := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second))
+	return strconv.FormatFloat(total.Seconds(), 'f', 3, 64)
+}
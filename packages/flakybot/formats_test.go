@@ -0,0 +1,200 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+func TestParseFormats(t *testing.T) {
+	got, err := parseFormats("sponge, trx,junit")
+	if err != nil {
+		t.Fatalf("parseFormats: %v", err)
+	}
+	if diff := cmp.Diff(formatNames(got), []string{"sponge", "trx", "junit"}); diff != "" {
+		t.Errorf("parseFormats got diff (-got, +want):\n%s", diff)
+	}
+	for _, in := range []string{"", "sponge,bogus"} {
+		if _, err := parseFormats(in); err == nil {
+			t.Errorf("parseFormats(%q) got nil error, want an error", in)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tmpdir := t.TempDir()
+	files := map[string]string{
+		"sponge_log.xml":        "unused",
+		"TEST-com.example.xml":  `<?xml version="1.0"?><testsuite name="com.example"></testsuite>`,
+		"results.xml":           `<test-run></test-run>`,
+		"xunit.xml":             `<assemblies></assemblies>`,
+		"run.trx":               `<TestRun xmlns="http://microsoft.com/schemas/VisualStudio/TeamTest/2010"></TestRun>`,
+		"pom.xml":               `<project></project>`,
+		"notes.txt":             "hello",
+		"python_sponge_log.xml": `<testsuites></testsuites>`,
+	}
+	want := map[string]string{
+		"sponge_log.xml":        "sponge",
+		"TEST-com.example.xml":  "junit",
+		"results.xml":           "nunit",
+		"xunit.xml":             "xunit",
+		"run.trx":               "trx",
+		"pom.xml":               "",
+		"notes.txt":             "",
+		"python_sponge_log.xml": "sponge",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+	}
+	for name, wantName := range want {
+		f, err := detectFormat(filepath.Join(tmpdir, name), reportFormats, false)
+		if err != nil {
+			t.Fatalf("detectFormat(%q): %v", name, err)
+		}
+		got := ""
+		if f != nil {
+			got = f.name
+		}
+		if got != wantName {
+			t.Errorf("detectFormat(%q) = %q, want %q", name, got, wantName)
+		}
+	}
+
+	logs, err := findLogs(&config{logsDir: tmpdir, formats: []*reportFormat{formatByName("sponge")}})
+	if err != nil {
+		t.Fatalf("findLogs: %v", err)
+	}
+	if got := len(logs); got != 2 {
+		t.Errorf("findLogs with sponge format found %d files, want 2: %v", got, logs)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		format string
+		in     string
+		want   *xunit.TestSuites
+	}{
+		{
+			format: "nunit",
+			in: `<?xml version="1.0" encoding="utf-16"?>
+<test-run id="2" result="Failed">
+  <test-suite type="Assembly" name="Example.Tests.dll" fullname="Example.Tests.dll">
+    <test-suite type="TestFixture" name="MathTests" fullname="Example.MathTests">
+      <test-case name="Adds" fullname="Example.MathTests.Adds" classname="Example.MathTests" result="Passed" duration="0.012"/>
+      <test-case name="Divides" fullname="Example.MathTests.Divides" classname="Example.MathTests" result="Failed" duration="0.001">
+        <failure><message><![CDATA[Expected 2]]></message><stack-trace><![CDATA[at MathTests.Divides()]]></stack-trace></failure>
+      </test-case>
+      <test-case name="Throws" classname="Example.MathTests" result="Failed" label="Error">
+        <failure><message>boom</message></failure>
+      </test-case>
+      <test-case name="Later" classname="Example.MathTests" result="Skipped" label="Ignored">
+        <reason><message>not yet</message></reason>
+      </test-case>
+    </test-suite>
+  </test-suite>
+</test-run>`,
+			want: &xunit.TestSuites{Suites: []*xunit.TestSuite{{
+				Name: "Example.MathTests", Tests: 4, Failures: 1, Errors: 1, Skipped: 1,
+				Cases: []*xunit.TestCase{
+					{ClassName: "Example.MathTests", Name: "Adds", Time: "0.012"},
+					{ClassName: "Example.MathTests", Name: "Divides", Time: "0.001", Failure: &xunit.Result{Message: "Expected 2", Text: "at MathTests.Divides()"}},
+					{ClassName: "Example.MathTests", Name: "Throws", Error: &xunit.Result{Message: "boom"}},
+					{ClassName: "Example.MathTests", Name: "Later", Skipped: &xunit.Result{Message: "not yet"}},
+				},
+			}}},
+		},
+		{
+			format: "xunit",
+			in: `<assemblies>
+  <assembly name="Example.Tests.dll">
+    <collection name="Test collection for Example.MathTests">
+      <test name="Example.MathTests.Adds" type="Example.MathTests" method="Adds" time="0.01" result="Pass"/>
+      <test name="Example.MathTests.Divides" type="Example.MathTests" method="Divides" time="0.02" result="Fail">
+        <failure exception-type="Xunit.Sdk.EqualException"><message>Assert.Equal() Failure</message><stack-trace>at Divides()</stack-trace></failure>
+      </test>
+      <test name="Example.OtherTests.Later" type="Example.OtherTests" method="Later" time="0" result="Skip"><reason>not yet</reason></test>
+    </collection>
+  </assembly>
+</assemblies>`,
+			want: &xunit.TestSuites{Suites: []*xunit.TestSuite{
+				{
+					Name: "Example.MathTests", Tests: 2, Failures: 1,
+					Cases: []*xunit.TestCase{
+						{ClassName: "Example.MathTests", Name: "Adds", Time: "0.01"},
+						{ClassName: "Example.MathTests", Name: "Divides", Time: "0.02", Failure: &xunit.Result{Message: "Assert.Equal() Failure", Type: "Xunit.Sdk.EqualException", Text: "at Divides()"}},
+					},
+				},
+				{
+					Name: "Example.OtherTests", Tests: 1, Skipped: 1,
+					Cases: []*xunit.TestCase{
+						{ClassName: "Example.OtherTests", Name: "Later", Time: "0", Skipped: &xunit.Result{Message: "not yet"}},
+					},
+				},
+			}},
+		},
+		{
+			format: "trx",
+			in: `<?xml version="1.0" encoding="utf-8"?>
+<TestRun xmlns="http://microsoft.com/schemas/VisualStudio/TeamTest/2010">
+  <Results>
+    <UnitTestResult testId="1" testName="Adds" outcome="Passed" duration="00:00:01.5000000"/>
+    <UnitTestResult testId="2" testName="Divides" outcome="Failed" duration="00:01:00.0000000">
+      <Output><ErrorInfo><Message>Expected 2</Message><StackTrace>at Divides()</StackTrace></ErrorInfo></Output>
+    </UnitTestResult>
+    <UnitTestResult testId="3" testName="Later" outcome="NotExecuted"/>
+  </Results>
+  <TestDefinitions>
+    <UnitTest name="Adds" id="1"><TestMethod className="Example.MathTests" name="Adds"/></UnitTest>
+    <UnitTest name="Divides" id="2"><TestMethod className="Example.MathTests" name="Divides"/></UnitTest>
+    <UnitTest name="Later" id="3"><TestMethod className="Example.MathTests" name="Later"/></UnitTest>
+  </TestDefinitions>
+</TestRun>`,
+			want: &xunit.TestSuites{Suites: []*xunit.TestSuite{{
+				Name: "Example.MathTests", Tests: 3, Failures: 1, Skipped: 1,
+				Cases: []*xunit.TestCase{
+					{ClassName: "Example.MathTests", Name: "Adds", Time: "1.500"},
+					{ClassName: "Example.MathTests", Name: "Divides", Time: "60.000", Failure: &xunit.Result{Message: "Expected 2", Text: "at Divides()"}},
+					{ClassName: "Example.MathTests", Name: "Later", Skipped: &xunit.Result{}},
+				},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			out, err := formatByName(test.format).normalize([]byte(test.in))
+			if err != nil {
+				t.Fatalf("normalize: %v", err)
+			}
+			got := &xunit.TestSuites{}
+			if err := xml.Unmarshal(out, got); err != nil {
+				t.Fatalf("xml.Unmarshal(%s): %v", out, err)
+			}
+			if diff := cmp.Diff(got, test.want, cmp.FilterPath(func(p cmp.Path) bool {
+				return p.Last().String() == ".XMLName"
+			}, cmp.Ignore())); diff != "" {
+				t.Errorf("normalize got diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
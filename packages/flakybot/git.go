@@ -0,0 +1,228 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitStartDir is where to start looking for a git checkout. Tests replace it.
+var gitStartDir = "."
+
+// gitRepo is a local git checkout.
+type gitRepo struct {
+	// root is the directory with the .git directory or file.
+	root string
+	// dir is the git directory, with HEAD. For worktrees, it's
+	// .git/worktrees/<name> in the main checkout.
+	dir string
+	// commonDir has the config, refs, and packed-refs. It's the same as dir,
+	// except for worktrees.
+	commonDir string
+}
+
+// findGitRepo finds the git checkout containing dir, by looking for a .git
+// directory or file in dir and its parents.
+func findGitRepo(dir string) (*gitRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		path := filepath.Join(dir, ".git")
+		fi, err := os.Stat(path)
+		if err == nil {
+			if fi.IsDir() {
+				return &gitRepo{root: dir, dir: path, commonDir: path}, nil
+			}
+			r, err := readGitFile(path)
+			if err != nil {
+				return nil, err
+			}
+			r.root = dir
+			return r, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("not in a git checkout")
+		}
+		dir = parent
+	}
+}
+
+// readGitFile reads a .git file, which worktrees and submodules use to point
+// to their git directory.
+func readGitFile(path string) (*gitRepo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't start with gitdir:", path)
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	r := &gitRepo{dir: gitDir, commonDir: gitDir}
+	// Worktrees share the config and refs of the main checkout.
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		c := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(c) {
+			c = filepath.Join(gitDir, c)
+		}
+		r.commonDir = filepath.Clean(c)
+	}
+	return r, nil
+}
+
+// head returns the commit checked out, and the branch if there is one.
+func (r *gitRepo) head() (commit, branch string, err error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+	ref, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref:")
+	if !ok {
+		// A detached HEAD.
+		return strings.TrimSpace(string(data)), "", nil
+	}
+	ref = strings.TrimSpace(ref)
+	commit, err = r.resolve(ref)
+	if err != nil {
+		return "", "", err
+	}
+	branch, _ = strings.CutPrefix(ref, "refs/heads/")
+	return commit, branch, nil
+}
+
+// resolve returns the commit a ref points to, from its ref file or
+// packed-refs.
+func (r *gitRepo) resolve(ref string) (string, error) {
+	for i := 0; i < 10; i++ {
+		value, err := r.readRef(ref)
+		if err != nil {
+			return "", err
+		}
+		next, ok := strings.CutPrefix(value, "ref:")
+		if !ok {
+			return value, nil
+		}
+		ref = strings.TrimSpace(next)
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs")
+}
+
+func (r *gitRepo) readRef(ref string) (string, error) {
+	// Per-worktree refs, like HEAD, live in dir. Everything else is shared.
+	for _, dir := range []string{r.dir, r.commonDir} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(ref)))
+		if err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("ref %s not found", ref)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		// Skip the header and peeled tags.
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		if sha, name, ok := strings.Cut(line, " "); ok && name == ref {
+			return sha, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("ref %s not found", ref)
+}
+
+// remotes returns the URL of every remote in the git config, by name.
+func (r *gitRepo) remotes() (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(r.commonDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	remotes := map[string]string{}
+	remote := ""
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			// Sections look like [remote "origin"].
+			remote = ""
+			section := strings.Trim(line, "[]")
+			if name, ok := strings.CutPrefix(section, "remote "); ok {
+				remote = strings.Trim(strings.TrimSpace(name), `"`)
+			}
+			continue
+		}
+		if remote == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "url") {
+			if _, seen := remotes[remote]; !seen {
+				remotes[remote] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return remotes, s.Err()
+}
+
+// localGitInfo returns the repo, commit, and branch of the git checkout
+// containing dir. The repo comes from the origin remote, or upstream if there
+// is no origin.
+func localGitInfo(dir string) (*buildInfo, error) {
+	r, err := findGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	info := &buildInfo{}
+	if info.commit, info.branch, err = r.head(); err != nil {
+		return nil, fmt.Errorf("reading HEAD: %v", err)
+	}
+	remotes, err := r.remotes()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %v", err)
+	}
+	for _, name := range []string{"origin", "upstream"} {
+		if u, ok := remotes[name]; ok {
+			info.repo = repoFromURL(u)
+			break
+		}
+	}
+	if info.repo == "" && len(remotes) == 1 {
+		for _, u := range remotes {
+			info.repo = repoFromURL(u)
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const (
+	sha1 = "0123456789abcdef0123456789abcdef01234567"
+	sha2 = "89abcdef0123456789abcdef0123456789abcdef"
+)
+
+// writeFiles creates the given files in dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+	}
+}
+
+func TestLocalGitInfo(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// dir is where to start looking, relative to the test directory.
+		dir  string
+		want *buildInfo
+	}{
+		{
+			name: "loose ref and HTTPS remote",
+			files: map[string]string{
+				".git/HEAD":            "ref: refs/heads/main\n",
+				".git/refs/heads/main": sha1 + "\n",
+				".git/config": `[core]
+	bare = false
+[remote "origin"]
+	url = https://github.com/googleapis/repo-automation-bots.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[branch "main"]
+	remote = origin
+`,
+			},
+			want: &buildInfo{repo: "googleapis/repo-automation-bots", commit: sha1, branch: "main"},
+		},
+		{
+			name: "packed refs and SSH remote from a subdirectory",
+			files: map[string]string{
+				".git/HEAD": "ref: refs/heads/feature\n",
+				".git/packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" +
+					sha2 + " refs/heads/feature\n" +
+					sha1 + " refs/tags/v1.0.0\n" +
+					"^" + sha2 + "\n",
+				".git/config": `[remote "upstream"]
+	url = git@github.com:GoogleCloudPlatform/golang-samples.git
+[remote "fork"]
+	url = git@github.com:someone/golang-samples.git
+`,
+				"packages/foo/sponge_log.xml": "unused",
+			},
+			dir:  "packages/foo",
+			want: &buildInfo{repo: "GoogleCloudPlatform/golang-samples", commit: sha2, branch: "feature"},
+		},
+		{
+			name: "detached HEAD",
+			files: map[string]string{
+				".git/HEAD":   sha1 + "\n",
+				".git/config": "[remote \"origin\"]\n\turl = https://github.com/googleapis/google-cloud-go\n",
+			},
+			want: &buildInfo{repo: "googleapis/google-cloud-go", commit: sha1},
+		},
+		{
+			name: "worktree",
+			files: map[string]string{
+				"main/.git/HEAD":                      "ref: refs/heads/main\n",
+				"main/.git/config":                    "[remote \"origin\"]\n\turl = ssh://git@github.com/googleapis/repo-automation-bots.git\n",
+				"main/.git/packed-refs":               sha2 + " refs/heads/feature\n",
+				"main/.git/worktrees/wt/HEAD":         "ref: refs/heads/feature\n",
+				"main/.git/worktrees/wt/commondir":    "../..\n",
+				"main/.git/worktrees/wt/gitdir":       "../../../../wt/.git\n",
+				"wt/.git":                             "gitdir: ../main/.git/worktrees/wt\n",
+				"wt/packages/flakybot/sponge_log.xml": "unused",
+			},
+			dir:  "wt/packages/flakybot",
+			want: &buildInfo{repo: "googleapis/repo-automation-bots", commit: sha2, branch: "feature"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+			got, err := localGitInfo(filepath.Join(dir, filepath.FromSlash(test.dir)))
+			if err != nil {
+				t.Fatalf("localGitInfo: %v", err)
+			}
+			if diff := cmp.Diff(got, test.want, cmp.AllowUnexported(buildInfo{})); diff != "" {
+				t.Errorf("localGitInfo got diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := localGitInfo(t.TempDir()); err == nil {
+		t.Errorf("localGitInfo outside a checkout got nil error, want an error")
+	}
+}
+
+func TestSetDefaultsFromLocalGit(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".git/HEAD":            "ref: refs/heads/main\n",
+		".git/refs/heads/main": sha1 + "\n",
+		".git/config":          "[remote \"origin\"]\n\turl = git@github.com:googleapis/repo-automation-bots.git\n",
+	})
+	getenv = fakeEnv{}.get
+	defer func() { getenv = os.Getenv }()
+	gitStartDir = dir
+	defer func() { gitStartDir = "." }()
+
+	cfg := &config{buildURL: "local"}
+	if ok := cfg.setDefaults(); !ok {
+		t.Fatalf("setDefaults got ok=false, want true")
+	}
+	want := &config{
+		repo:           "googleapis/repo-automation-bots",
+		installationID: "6370238",
+		commit:         sha1,
+		buildURL:       "local",
+		branch:         "main",
+		mode:           modeContinuous,
+		buildStart:     processStart,
+	}
+	if diff := cmp.Diff(cfg, want, cmp.AllowUnexported(config{}, retryPolicy{}), cmpopts.IgnoreFields(config{}, "sources")); diff != "" {
+		t.Errorf("setDefaults got diff (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultInstallationsYAML is the built-in table of installation IDs.
+//
+//go:embed installations.yaml
+var defaultInstallationsYAML []byte
+
+// defaultInstallations is parsed from defaultInstallationsYAML.
+var defaultInstallations = mustParseInstallations(defaultInstallationsYAML)
+
+// installationTable maps owners and repos to installation IDs. Keys are
+// lowercase, and start with the GitHub host, like github.com/googleapis or
+// github.com/googleapis/google-cloud-go.
+type installationTable map[string]string
+
+// parseInstallations parses a YAML or JSON object of owners, repos, or
+// host/owner[/repo] patterns to numeric installation IDs.
+func parseInstallations(data []byte) (installationTable, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	t := installationTable{}
+	for key, value := range raw {
+		var id string
+		switch v := value.(type) {
+		case int:
+			id = strconv.Itoa(v)
+		case uint64:
+			id = strconv.FormatUint(v, 10)
+		case string:
+			id = v
+		default:
+			return nil, fmt.Errorf("installation ID of %q is %v, want a number", key, value)
+		}
+		if n, err := strconv.ParseUint(id, 10, 64); err != nil || n == 0 {
+			return nil, fmt.Errorf("installation ID of %q is %q, want a positive number", key, id)
+		}
+		k, err := installationKey(key)
+		if err != nil {
+			return nil, err
+		}
+		t[k] = id
+	}
+	return t, nil
+}
+
+func mustParseInstallations(data []byte) installationTable {
+	t, err := parseInstallations(data)
+	if err != nil {
+		panic(fmt.Sprintf("parsing built-in installations: %v", err))
+	}
+	return t
+}
+
+// installationKey normalizes owner, owner/repo, host/owner, or
+// host/owner/repo to lowercase host/owner[/repo].
+func installationKey(key string) (string, error) {
+	parts := strings.Split(strings.ToLower(strings.Trim(key, "/")), "/")
+	// Only hosts have dots. GitHub owners can't.
+	if !strings.Contains(parts[0], ".") {
+		parts = append([]string{"github.com"}, parts...)
+	}
+	if len(parts) > 3 || slices.Contains(parts, "") {
+		return "", fmt.Errorf("%q isn't an owner, owner/repo, or host/owner[/repo]", key)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// merge returns a table with the entries of t and other. other takes
+// precedence.
+func (t installationTable) merge(other installationTable) installationTable {
+	merged := installationTable{}
+	for k, v := range t {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
+// lookup returns the installation ID of repo (owner/name) on host, preferring
+// an entry for the repo over one for its owner. host defaults to github.com.
+func (t installationTable) lookup(host, repo string) string {
+	if host == "" {
+		host = "github.com"
+	}
+	owner, name, ok := strings.Cut(strings.ToLower(repo), "/")
+	if !ok || owner == "" {
+		return ""
+	}
+	host = strings.ToLower(host)
+	if id, ok := t[host+"/"+owner+"/"+name]; ok {
+		return id
+	}
+	return t[host+"/"+owner]
+}
+
+// loadInstallations returns the built-in installations, overridden by the
+// ones in path (if set), then the ones in env (the contents of the
+// FLAKYBOT_INSTALLATIONS environment variable, if set).
+func loadInstallations(path, env string) (installationTable, error) {
+	t := defaultInstallations
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parseInstallations(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+		t = t.merge(file)
+	}
+	if env != "" {
+		fromEnv, err := parseInstallations([]byte(env))
+		if err != nil {
+			return nil, fmt.Errorf("parsing FLAKYBOT_INSTALLATIONS: %v", err)
+		}
+		t = t.merge(fromEnv)
+	}
+	return t, nil
+}
+
+// githubHost returns the GitHub host of a GitHub API URL, like github.com for
+// https://api.github.com or github.example.com for
+// https://github.example.com/api/v3.
+func githubHost(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return "github.com"
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "api.github.com" {
+		return "github.com"
+	}
+	return strings.TrimPrefix(host, "api.")
+}
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+// installationResolver looks up the installation ID of a GitHub App on a repo
+// with the GitHub API.
+type installationResolver struct {
+	appID   string
+	key     *rsa.PrivateKey
+	baseURL string
+	client  *http.Client
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// newInstallationResolver returns a resolver for the GitHub App with the given
+// ID and the PEM encoded private key in keyFile. baseURL defaults to
+// https://api.github.com.
+func newInstallationResolver(appID, keyFile, baseURL string) (*installationResolver, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", keyFile, err)
+	}
+	if baseURL == "" {
+		baseURL = defaultGitHubAPIURL
+	}
+	return &installationResolver{
+		appID:   appID,
+		key:     key,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+		cache:   map[string]string{},
+	}, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key,
+// like the ones GitHub generates for apps.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("got a %T, want an RSA private key", key)
+	}
+	return rsaKey, nil
+}
+
+// resolve returns the installation ID of the app on repo (owner/name).
+func (r *installationResolver) resolve(ctx context.Context, repo string) (string, error) {
+	r.mu.Lock()
+	id, ok := r.cache[repo]
+	r.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" {
+		return "", fmt.Errorf("repo %q isn't owner/name", repo)
+	}
+	jwt, err := r.jwt()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/repos/%s/%s/installation", r.baseURL, owner, name), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s: %s", req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(body, &installation); err != nil {
+		return "", fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	if installation.ID == 0 {
+		return "", fmt.Errorf("GET %s: no installation ID in the response", req.URL)
+	}
+	id = strconv.FormatInt(installation.ID, 10)
+
+	r.mu.Lock()
+	r.cache[repo] = id
+	r.mu.Unlock()
+	return id, nil
+}
+
+// jwt returns a JSON Web Token to authenticate as the app. See
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app.
+func (r *installationResolver) jwt() (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		// Allow for clock drift.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": r.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, r.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("signing JWT: %v", err)
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeGitHub serves GET /repos/{owner}/{repo}/installation for the repos in
+// installations, checking the JWT is signed by key.
+func fakeGitHub(key *rsa.PrivateKey, appID string, installations map[string]int64, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, "no JWT", http.StatusUnauthorized)
+			return
+		}
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			http.Error(w, "bad JWT", http.StatusUnauthorized)
+			return
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			http.Error(w, "bad signature encoding", http.StatusUnauthorized)
+			return
+		}
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims struct {
+			Iss string `json:"iss"`
+			Iat int64  `json:"iat"`
+			Exp int64  `json:"exp"`
+		}
+		if err := json.Unmarshal(claimsJSON, &claims); err != nil || claims.Iss != appID || claims.Exp-claims.Iat > 600 {
+			http.Error(w, "bad claims", http.StatusUnauthorized)
+			return
+		}
+		repo, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/installation")
+		id, found := installations[repo]
+		if r.Method != http.MethodGet || !ok || !found {
+			http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"id": %d, "app_id": 1}`, id)
+	}))
+}
+
+// writeKey writes key to a PEM file and returns its path.
+func writeKey(t *testing.T, key *rsa.PrivateKey, pkcs8 bool) string {
+	t.Helper()
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("x509.MarshalPKCS8PrivateKey: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestInstallationResolver(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	calls := 0
+	server := fakeGitHub(key, "1234", map[string]int64{"my-org/my-repo": 42}, &calls)
+	defer server.Close()
+
+	for _, pkcs8 := range []bool{false, true} {
+		calls = 0
+		r, err := newInstallationResolver("1234", writeKey(t, key, pkcs8), server.URL+"/")
+		if err != nil {
+			t.Fatalf("newInstallationResolver: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			got, err := r.resolve(context.Background(), "my-org/my-repo")
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if got != "42" {
+				t.Errorf("resolve got %q, want 42", got)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("resolve called the API %d times, want 1 (the second should be cached)", calls)
+		}
+		if _, err := r.resolve(context.Background(), "my-org/other-repo"); err == nil {
+			t.Errorf("resolve for an uninstalled repo got nil error, want an error")
+		}
+		if _, err := r.resolve(context.Background(), "not-a-repo"); err == nil {
+			t.Errorf("resolve for a bad repo got nil error, want an error")
+		}
+	}
+
+	// A JWT signed with the wrong key is rejected.
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	r, err := newInstallationResolver("1234", writeKey(t, other, false), server.URL)
+	if err != nil {
+		t.Fatalf("newInstallationResolver: %v", err)
+	}
+	if _, err := r.resolve(context.Background(), "my-org/my-repo"); err == nil {
+		t.Errorf("resolve with the wrong key got nil error, want an error")
+	}
+}
+
+func TestSetDefaultsInstallationFallback(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	getenv = fakeEnv{}.get
+	defer func() { getenv = os.Getenv }()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	calls := 0
+	server := fakeGitHub(key, "1234", map[string]int64{"my-org/my-repo": 42}, &calls)
+	defer server.Close()
+	r, err := newInstallationResolver("1234", writeKey(t, key, false), server.URL)
+	if err != nil {
+		t.Fatalf("newInstallationResolver: %v", err)
+	}
+
+	for repo, want := range map[string]string{
+		"my-org/my-repo":                  "42",
+		"googleapis/repo-automation-bots": "6370238",
+	} {
+		cfg := &config{repo: repo, commit: "abc123", buildURL: "local", installations: r}
+		if ok := cfg.setDefaults(); !ok {
+			t.Fatalf("setDefaults(%q) got ok=false, want true", repo)
+		}
+		if cfg.installationID != want {
+			t.Errorf("setDefaults(%q) got installation ID %q, want %q", repo, cfg.installationID, want)
+		}
+	}
+}
+
+func TestLoadInstallations(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "installations.yaml")
+	if err := os.WriteFile(yamlFile, []byte(`
+# My orgs.
+my-org: 111
+my-org/special-repo: "222"
+googleapis: 333
+github.example.com/my-org: 444
+`), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	jsonFile := filepath.Join(dir, "installations.json")
+	if err := os.WriteFile(jsonFile, []byte(`{"json-org": 555}`), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	table, err := loadInstallations(yamlFile, `{"env-org": 666, "My-Org": "777"}`)
+	if err != nil {
+		t.Fatalf("loadInstallations: %v", err)
+	}
+	tests := []struct {
+		host, repo string
+		want       string
+	}{
+		{repo: "my-org/any-repo", want: "777"},
+		{repo: "my-org/special-repo", want: "222"},
+		{repo: "MY-ORG/Special-Repo", want: "222"},
+		{repo: "googleapis/google-cloud-go", want: "333"},
+		{repo: "GoogleCloudPlatform/golang-samples", want: "5943459"},
+		{repo: "env-org/repo", want: "666"},
+		{host: "github.example.com", repo: "my-org/repo", want: "444"},
+		{host: "github.example.com", repo: "googleapis/repo", want: ""},
+		{repo: "other/repo", want: ""},
+	}
+	for _, test := range tests {
+		if got := table.lookup(test.host, test.repo); got != test.want {
+			t.Errorf("lookup(%q, %q) = %q, want %q", test.host, test.repo, got, test.want)
+		}
+	}
+
+	table, err = loadInstallations(jsonFile, "")
+	if err != nil {
+		t.Fatalf("loadInstallations(%s): %v", jsonFile, err)
+	}
+	if got := table.lookup("", "json-org/repo"); got != "555" {
+		t.Errorf("lookup from JSON = %q, want 555", got)
+	}
+	if got := defaultInstallations.lookup("", "json-org/repo"); got != "" {
+		t.Errorf("loading a file changed the built-in installations: got %q", got)
+	}
+}
+
+func TestParseInstallationsErrors(t *testing.T) {
+	for _, in := range []string{
+		`my-org: abc`,
+		`my-org: 1.5`,
+		`my-org: -3`,
+		`my-org: 0`,
+		`{"my-org": true}`,
+		`a/b/c/d: 1`,
+		`my-org//repo: 1`,
+		`[1, 2]`,
+	} {
+		if _, err := parseInstallations([]byte(in)); err == nil {
+			t.Errorf("parseInstallations(%q) got nil error, want an error", in)
+		}
+	}
+}
+
+func TestGitHubHost(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://api.github.com":            "github.com",
+		"https://api.github.com/":           "github.com",
+		"https://github.example.com/api/v3": "github.example.com",
+		"https://api.github.example.com":    "github.example.com",
+		"http://127.0.0.1:8080":             "127.0.0.1",
+		"":                                  "github.com",
+	} {
+		if got := githubHost(in); got != want {
+			t.Errorf("githubHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
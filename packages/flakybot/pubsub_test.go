@@ -0,0 +1,214 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const testReport = `<testsuites><testsuite name="pkg"><testcase name="TestA"></testcase></testsuite></testsuites>`
+
+// newFakePubSub starts an in-memory Pub/Sub server with the given topics in
+// project p.
+func newFakePubSub(t *testing.T, topics ...string) *pstest.Server {
+	t.Helper()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "p",
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatalf("pubsub.NewClient: %v", err)
+	}
+	defer client.Close()
+	for _, topic := range topics {
+		if _, err := client.CreateTopic(ctx, topic); err != nil {
+			t.Fatalf("CreateTopic(%s): %v", topic, err)
+		}
+	}
+	return srv
+}
+
+func TestPublishMainPubSub(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(logsDir, "sponge_log.xml"), []byte(testReport), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	args := []string{
+		"-logs_dir", logsDir,
+		"-repo", "googleapis/repo-automation-bots",
+		"-commit_hash", "abc123",
+		"-build_url", "local",
+		"-project", "p",
+		"-max_attempts", "1",
+	}
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+
+	t.Run("emulator host", func(t *testing.T) {
+		srv := newFakePubSub(t, "flakybot")
+		getenv = fakeEnv{"PUBSUB_EMULATOR_HOST": srv.Addr}.get
+		defer func() { getenv = os.Getenv }()
+
+		if got := run(append(args, "-topic", "flakybot")); got != 0 {
+			t.Fatalf("run got exit code %d, want 0", got)
+		}
+		msgs := srv.Messages()
+		if len(msgs) != 1 {
+			t.Fatalf("published %d messages, want 1", len(msgs))
+		}
+		m := &message{}
+		if err := json.Unmarshal(msgs[0].Data, m); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		xml, err := m.decodeXUnitXML()
+		if err != nil {
+			t.Fatalf("decodeXUnitXML: %v", err)
+		}
+		got := []string{m.Name, m.Repo, m.Commit, m.BuildURL, m.Installation.ID, string(xml)}
+		want := []string{"flakybot", "googleapis/repo-automation-bots", "abc123", "local", "6370238", testReport}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("published message diff (-got, +want):\n%s", diff)
+		}
+		sum := sha256.Sum256([]byte(testReport))
+		wantAttrs := map[string]string{
+			"bot":            "flakybot",
+			"repo":           "googleapis/repo-automation-bots",
+			"commit":         "abc123",
+			"path":           "sponge_log.xml",
+			"contentHash":    hex.EncodeToString(sum[:]),
+			"schemaVersion":  schemaVersion,
+			"idempotencyKey": idempotencyKey("googleapis/repo-automation-bots", "abc123", hex.EncodeToString(sum[:])),
+		}
+		if diff := cmp.Diff(msgs[0].Attributes, wantAttrs); diff != "" {
+			t.Errorf("published attributes diff (-got, +want):\n%s", diff)
+		}
+		if msgs[0].OrderingKey != "" {
+			t.Errorf("published ordering key %q without --ordered, want none", msgs[0].OrderingKey)
+		}
+	})
+
+	t.Run("endpoint flag", func(t *testing.T) {
+		srv := newFakePubSub(t, "flakybot")
+		getenv = fakeEnv{}.get
+		defer func() { getenv = os.Getenv }()
+
+		if got := run(append(args, "-topic", "flakybot", "-pubsub_endpoint", srv.Addr, "-ordered")); got != 0 {
+			t.Fatalf("run got exit code %d, want 0", got)
+		}
+		msgs := srv.Messages()
+		if len(msgs) != 1 {
+			t.Fatalf("published %d messages, want 1", len(msgs))
+		}
+		if got := msgs[0].OrderingKey; got != "googleapis/repo-automation-bots" {
+			t.Errorf("published ordering key %q, want the repo", got)
+		}
+	})
+
+	t.Run("missing topic", func(t *testing.T) {
+		srv := newFakePubSub(t, "flakybot")
+		getenv = fakeEnv{}.get
+		defer func() { getenv = os.Getenv }()
+
+		if got := run(append(args, "-topic", "typo", "-pubsub_endpoint", srv.Addr)); got != exitConfigError {
+			t.Errorf("run got exit code %d, want %d", got, exitConfigError)
+		}
+		if got := len(srv.Messages()); got != 0 {
+			t.Errorf("published %d messages, want 0", got)
+		}
+	})
+}
+
+func TestFlushMainPubSub(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := newFakePubSub(t, "flakybot")
+	getenv = fakeEnv{"PUBSUB_EMULATOR_HOST": srv.Addr}.get
+	defer func() { getenv = os.Getenv }()
+
+	dir := t.TempDir()
+	if _, err := spool(dir, &spooledMessage{Project: "p", Topic: "flakybot", Data: []byte("spooled")}); err != nil {
+		t.Fatalf("spool: %v", err)
+	}
+	if got := run([]string{"flush", "-spool_dir", dir}); got != 0 {
+		t.Fatalf("flush got exit code %d, want 0", got)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 || string(msgs[0].Data) != "spooled" {
+		t.Errorf("flush published %v, want one spooled message", msgs)
+	}
+}
+
+func TestPublisherResumesOrderingKey(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := newFakePubSub(t, "flakybot")
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "p",
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatalf("pubsub.NewClient: %v", err)
+	}
+	defer client.Close()
+	topic := client.Topic("flakybot")
+	topic.EnableMessageOrdering = true
+	defer topic.Stop()
+
+	srv.SetAutoPublishResponse(false)
+	srv.AddPublishResponse(nil, status.Error(codes.FailedPrecondition, "failed"))
+	srv.AddPublishResponse(&pubsubpb.PublishResponse{MessageIds: []string{"id-1"}}, nil)
+
+	// Another message with the same key failing pauses the key, like when
+	// reports are published in parallel with --ordered.
+	const key = "googleapis/repo-automation-bots"
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: []byte("a"), OrderingKey: key}).Get(ctx); err == nil {
+		t.Fatalf("Publish got nil error, want the injected error")
+	}
+	r := newRetryPublisher(&publisher{topic: topic}, retryPolicy{maxAttempts: 2, initialBackoff: time.Millisecond})
+	id, err := r.publish(ctx, &pubsub.Message{Data: []byte("b"), OrderingKey: key})
+	if err != nil || id != "id-1" {
+		t.Errorf("publish after the key was paused got %q, %v, want id-1", id, err)
+	}
+}
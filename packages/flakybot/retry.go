@@ -0,0 +1,146 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryPolicy is how to retry messages that fail to publish.
+type retryPolicy struct {
+	// maxAttempts is how many times to try publishing a message, including
+	// the first time.
+	maxAttempts int
+	// initialBackoff is how long to wait before the first retry. The wait
+	// doubles after every attempt, up to maxBackoff.
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// deadline is how long to spend on a message, including all retries. Zero
+	// means no limit.
+	deadline time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts:    5,
+	initialBackoff: time.Second,
+	maxBackoff:     30 * time.Second,
+	deadline:       2 * time.Minute,
+}
+
+// retryPublisher retries messages that fail with a transient error.
+type retryPublisher struct {
+	p      messagePublisher
+	policy retryPolicy
+
+	// sleep waits for d, or until ctx is done. Tests replace it.
+	sleep func(ctx context.Context, d time.Duration) error
+	// jitter returns a random duration in [0, d). Tests replace it.
+	jitter func(d time.Duration) time.Duration
+}
+
+func newRetryPublisher(p messagePublisher, policy retryPolicy) *retryPublisher {
+	return &retryPublisher{
+		p:      p,
+		policy: policy,
+		sleep:  sleepContext,
+		jitter: func(d time.Duration) time.Duration {
+			if d <= 0 {
+				return 0
+			}
+			return rand.N(d)
+		},
+	}
+}
+
+func (r *retryPublisher) publish(ctx context.Context, msg *pubsub.Message) (serverID string, err error) {
+	if r.policy.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.policy.deadline)
+		defer cancel()
+	}
+	attempts := max(r.policy.maxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		id, err := r.p.publish(ctx, msg)
+		if err == nil {
+			return id, nil
+		}
+		if !retryable(err) {
+			return "", err
+		}
+		if attempt >= attempts {
+			// Wrap err, so callers can tell the failure was transient.
+			return "", fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		wait := r.backoff(attempt)
+		log.Printf("Publish attempt %d of %d failed, retrying in %v: %v", attempt, attempts, wait.Round(time.Millisecond), err)
+		if sleepErr := r.sleep(ctx, wait); sleepErr != nil {
+			return "", fmt.Errorf("giving up after %d attempts (%v): %w", attempt, sleepErr, err)
+		}
+	}
+}
+
+// backoff returns how long to wait after the given attempt fails: half the
+// exponential backoff plus a random amount up to the other half, so many
+// builds failing at once don't all retry at the same time.
+func (r *retryPublisher) backoff(attempt int) time.Duration {
+	d := r.policy.initialBackoff
+	for i := 1; i < attempt && d < r.policy.maxBackoff; i++ {
+		d *= 2
+	}
+	if r.policy.maxBackoff > 0 && d > r.policy.maxBackoff {
+		d = r.policy.maxBackoff
+	}
+	return d/2 + r.jitter(d-d/2)
+}
+
+// retryable reports whether a publish error is worth retrying. Errors can say
+// with a retryable method. Messages rejected because another message with the
+// same ordering key failed can be retried once the publisher resumes the key.
+// Other errors that aren't gRPC errors are assumed to be permanent.
+func retryable(err error) bool {
+	var r interface{ retryable() bool }
+	if errors.As(err, &r) {
+		return r.retryable()
+	}
+	var paused pubsub.ErrPublishingPaused
+	if errors.As(err, &paused) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryPublisher(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	deadline := status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	policy := retryPolicy{maxAttempts: 4, initialBackoff: time.Second, maxBackoff: 3 * time.Second}
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantSleeps   []time.Duration
+		wantErr      bool
+		// wantTransient is whether the error is still retryable, so the
+		// message can be spooled.
+		wantTransient bool
+	}{
+		{
+			name:         "success",
+			wantAttempts: 1,
+		},
+		{
+			name:         "transient",
+			errs:         []error{unavailable, deadline},
+			wantAttempts: 3,
+			wantSleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:          "gives up",
+			errs:          []error{unavailable, unavailable, unavailable, unavailable, unavailable},
+			wantAttempts:  4,
+			wantSleeps:    []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+			wantErr:       true,
+			wantTransient: true,
+		},
+		{
+			name:         "fatal",
+			errs:         []error{unavailable, status.Error(codes.PermissionDenied, "denied")},
+			wantAttempts: 2,
+			wantSleeps:   []time.Duration{time.Second},
+			wantErr:      true,
+		},
+		{
+			name:         "ordering key paused",
+			errs:         []error{pubsub.ErrPublishingPaused{OrderingKey: "repo"}},
+			wantAttempts: 2,
+			wantSleeps:   []time.Duration{time.Second},
+		},
+		{
+			name:         "not gRPC",
+			errs:         []error{errors.New("boom")},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakePublisher{errs: test.errs}
+			r := newRetryPublisher(fake, policy)
+			var sleeps []time.Duration
+			r.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+			// Take the maximum jitter, so the sleeps are the full backoff.
+			r.jitter = func(d time.Duration) time.Duration { return d }
+
+			_, err := r.publish(context.Background(), &pubsub.Message{Data: []byte("data")})
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("publish got err %v, want error: %v", err, test.wantErr)
+			}
+			if fake.attempts != test.wantAttempts {
+				t.Errorf("publish made %d attempts, want %d", fake.attempts, test.wantAttempts)
+			}
+			if diff := cmp.Diff(sleeps, test.wantSleeps); diff != "" {
+				t.Errorf("publish sleeps diff (-got, +want):\n%s", diff)
+			}
+			if test.wantErr && retryable(err) != test.wantTransient {
+				t.Errorf("retryable(%v) = %v, want %v", err, !test.wantTransient, test.wantTransient)
+			}
+		})
+	}
+}
+
+func TestRetryPublisherDeadline(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	fake := &fakePublisher{errs: []error{status.Error(codes.Unavailable, "unavailable")}}
+	r := newRetryPublisher(fake, retryPolicy{
+		maxAttempts:    5,
+		initialBackoff: time.Hour,
+		maxBackoff:     time.Hour,
+		deadline:       10 * time.Millisecond,
+	})
+	start := time.Now()
+	if _, err := r.publish(context.Background(), &pubsub.Message{}); err == nil {
+		t.Errorf("publish got nil error, want the deadline to be exceeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("publish took %v, want it to stop at the deadline", elapsed)
+	}
+	if fake.attempts != 1 {
+		t.Errorf("publish made %d attempts, want 1", fake.attempts)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	r := newRetryPublisher(&fakePublisher{}, retryPolicy{initialBackoff: time.Second, maxBackoff: 10 * time.Second})
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: 10 * time.Second} {
+		for i := 0; i < 100; i++ {
+			got := r.backoff(attempt)
+			if got < want/2 || got > want {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, got, want/2, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// defaultMaxMessageBytes is the default maximum size of a message's data.
+// Pub/Sub allows 10 MB per message, including attributes, so leave some room.
+const defaultMaxMessageBytes = 9 * 1000 * 1000
+
+// splitReport splits an xUnit XML report into several valid reports of at
+// most maxBytes each.
+//
+// Reports with a testsuites root are split between testsuite elements. Test
+// suites are never split, since the bot handles every part on its own: a part
+// with only the passes of a package could close the issue for failures in
+// another part. So a test suite that's too big on its own (or a report with a
+// testsuite root) is an error.
+func splitReport(data []byte, maxBytes int) ([][]byte, error) {
+	root, err := parseElement(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if root.name != "testsuites" {
+		return nil, fmt.Errorf("can't split a report with a %q root element, only one with a testsuites root", root.name)
+	}
+	header, footer := data[:root.childrenStart], data[root.childrenEnd:]
+	suites := root.children("testsuite")
+	for _, suite := range suites {
+		if n := len(header) + len(suite.bytes) + len(footer); n > maxBytes {
+			return nil, fmt.Errorf("test suite %q is %d bytes on its own, more than the maximum of %d, and test suites can't be split", suiteName(suite), n, maxBytes)
+		}
+	}
+	return pack(suites, header, footer, maxBytes)
+}
+
+// suiteName returns the name attribute of a testsuite element.
+func suiteName(suite fragment) string {
+	d := xml.NewDecoder(bytes.NewReader(suite.bytes))
+	d.CharsetReader = passthroughCharset
+	tok, err := d.Token()
+	if err != nil {
+		return ""
+	}
+	if start, ok := tok.(xml.StartElement); ok {
+		for _, a := range start.Attr {
+			if a.Name.Local == "name" {
+				return a.Value
+			}
+		}
+	}
+	return ""
+}
+
+// pack greedily groups pieces into documents of at most maxBytes, each
+// starting with header and ending with footer.
+func pack(pieces []fragment, header, footer []byte, maxBytes int) ([][]byte, error) {
+	var docs [][]byte
+	var cur []byte
+	flush := func() {
+		doc := make([]byte, 0, len(header)+len(cur)+len(footer))
+		doc = append(append(append(doc, header...), cur...), footer...)
+		docs = append(docs, doc)
+		cur = nil
+	}
+	for _, p := range pieces {
+		if len(header)+len(p.bytes)+len(footer) > maxBytes {
+			return nil, fmt.Errorf("a single element is %d bytes, which is too big to split into parts of %d bytes", len(p.bytes), maxBytes)
+		}
+		if cur != nil && len(header)+len(cur)+len(p.bytes)+len(footer) > maxBytes {
+			flush()
+		}
+		cur = append(cur, p.bytes...)
+	}
+	if cur != nil || len(docs) == 0 {
+		flush()
+	}
+	return docs, nil
+}
+
+// fragment is the raw bytes of an element and its offset in the document.
+type fragment struct {
+	name   string
+	bytes  []byte
+	offset int64
+}
+
+// element is the location of an element in a document.
+type element struct {
+	name string
+	// start and end are the offsets of the start of its start tag and the end
+	// of its end tag.
+	start, end int64
+	// childrenStart and childrenEnd are the offsets of the end of its start
+	// tag and the start of its end tag.
+	childrenStart, childrenEnd int64
+	// raw are its child elements.
+	raw []fragment
+}
+
+// children returns the child elements called name. Whitespace and comments
+// between them aren't included.
+func (e *element) children(name string) []fragment {
+	var f []fragment
+	for _, c := range e.raw {
+		if c.name == name {
+			f = append(f, c)
+		}
+	}
+	return f
+}
+
+// parseElement finds the first element at or after offset in data.
+func parseElement(data []byte, offset int64) (*element, error) {
+	d := xml.NewDecoder(bytes.NewReader(data[offset:]))
+	d.CharsetReader = passthroughCharset
+	var e *element
+	for {
+		before := offset + d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no complete root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if e == nil {
+				e = &element{
+					name:          t.Name.Local,
+					start:         before,
+					childrenStart: offset + d.InputOffset(),
+				}
+				continue
+			}
+			if err := d.Skip(); err != nil {
+				return nil, err
+			}
+			e.raw = append(e.raw, fragment{
+				name:   t.Name.Local,
+				bytes:  data[before : offset+d.InputOffset()],
+				offset: before,
+			})
+		case xml.EndElement:
+			e.childrenEnd = before
+			e.end = offset + d.InputOffset()
+			return e, nil
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+func TestSplitReport(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		maxBytes  int
+		wantParts int
+	}{
+		{fixture: "one_failed.xml", maxBytes: 1 << 20, wantParts: 1},
+		{fixture: "one_failed.xml", maxBytes: 600, wantParts: 2},
+		{fixture: "many_failed_same_pkg.xml", maxBytes: 1300, wantParts: 3},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("test/fixtures/testdata", test.fixture))
+		if err != nil {
+			t.Fatalf("os.ReadFile: %v", err)
+		}
+		want, err := xunit.FindTestResults(data)
+		if err != nil {
+			t.Fatalf("FindTestResults(%s): %v", test.fixture, err)
+		}
+		parts, err := splitReport(data, test.maxBytes)
+		if err != nil {
+			t.Fatalf("splitReport(%s, %d): %v", test.fixture, test.maxBytes, err)
+		}
+		if test.wantParts != 0 && len(parts) != test.wantParts {
+			t.Errorf("splitReport(%s, %d) got %d parts, want %d", test.fixture, test.maxBytes, len(parts), test.wantParts)
+		}
+		if test.wantParts == 0 && len(parts) < 2 {
+			t.Errorf("splitReport(%s, %d) got %d parts, want more than 1", test.fixture, test.maxBytes, len(parts))
+		}
+		got := &xunit.Results{}
+		for _, p := range parts {
+			if len(p) > test.maxBytes {
+				t.Errorf("splitReport(%s, %d) got a part of %d bytes", test.fixture, test.maxBytes, len(p))
+			}
+			r, err := xunit.FindTestResults(p)
+			if err != nil {
+				t.Fatalf("FindTestResults(part of %s): %v\n%s", test.fixture, err, p)
+			}
+			got.Passes = append(got.Passes, r.Passes...)
+			got.Failures = append(got.Failures, r.Failures...)
+		}
+		if diff := cmp.Diff(len(got.Passes)+len(got.Failures), len(want.Passes)+len(want.Failures)); diff != "" {
+			t.Errorf("splitReport(%s, %d) parts have a different number of tests (-got, +want):\n%s", test.fixture, test.maxBytes, diff)
+		}
+	}
+
+	// Test suites are never split, so the bot sees all of a package's
+	// results at once.
+	for _, fixture := range []string{"node_group.xml", "ruby_one_failed.xml", "many_failed_same_pkg.xml"} {
+		data, err := os.ReadFile(filepath.Join("test/fixtures/testdata", fixture))
+		if err != nil {
+			t.Fatalf("os.ReadFile: %v", err)
+		}
+		if _, err := splitReport(data, 800); err == nil {
+			t.Errorf("splitReport(%s, 800) got nil error, want an error for a test suite that's too big", fixture)
+		}
+	}
+}
+
+// manySuites returns a report with n test suites, each with cases failing
+// test cases.
+func manySuites(n, cases int) []byte {
+	var b strings.Builder
+	b.WriteString("<testsuites>")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `<testsuite name="example.com/pkg%d">`, i)
+		for j := 0; j < cases; j++ {
+			fmt.Fprintf(&b, `<testcase classname="pkg%d" name="TestNumber%d"><failure message="expected %d">stack trace</failure></testcase>`, i, j, j)
+		}
+		b.WriteString("</testsuite>")
+	}
+	b.WriteString("</testsuites>")
+	return []byte(b.String())
+}
+
+func TestBuildMessagesSplits(t *testing.T) {
+	data := manySuites(20, 5)
+	cfg := &config{
+		installationID:  "123",
+		repo:            "googleapis/repo-automation-bots",
+		commit:          "abc123",
+		buildURL:        "https://example.com",
+		maxMessageBytes: 8000,
+	}
+	msgs, err := buildMessages(cfg, data, contentHash(data))
+	if err != nil {
+		t.Fatalf("buildMessages: %v", err)
+	}
+	if len(msgs) < 2 {
+		t.Fatalf("buildMessages got %d messages, want more than 1", len(msgs))
+	}
+	suites := 0
+	for i, pubsubMsg := range msgs {
+		if len(pubsubMsg.Data) > cfg.maxMessageBytes {
+			t.Errorf("message %d is %d bytes, want at most %d", i, len(pubsubMsg.Data), cfg.maxMessageBytes)
+		}
+		msg := &message{}
+		if err := json.Unmarshal(pubsubMsg.Data, msg); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		if msg.ChunkIndex != i+1 || msg.ChunkCount != len(msgs) || msg.ChunkGroup == "" {
+			t.Errorf("message %d has chunk %d of %d (group %q), want %d of %d", i, msg.ChunkIndex, msg.ChunkCount, msg.ChunkGroup, i+1, len(msgs))
+		}
+		xml, err := base64.StdEncoding.DecodeString(msg.XUnitXML)
+		if err != nil {
+			t.Fatalf("base64 decode: %v", err)
+		}
+		if _, err := xunit.FindTestResults(xml); err != nil {
+			t.Errorf("message %d has invalid XML: %v", i, err)
+		}
+		suites += strings.Count(string(xml), "</testsuite>")
+	}
+	if suites != 20 {
+		t.Errorf("buildMessages parts have %d test suites, want 20", suites)
+	}
+
+	// A test suite too big for one message isn't split.
+	big := manySuites(1, 100)
+	if _, err := buildMessages(cfg, big, contentHash(big)); err == nil || !strings.Contains(err.Error(), "--compress") {
+		t.Errorf("buildMessages with a test suite that's too big got error %v, want one suggesting --compress", err)
+	}
+}
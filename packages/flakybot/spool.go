@@ -0,0 +1,253 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// spooledMessage is a message that couldn't be published, saved with
+// everything needed to publish it later.
+type spooledMessage struct {
+	Project     string            `json:"project"`
+	Topic       string            `json:"topic"`
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// spoolPublisher publishes messages with p, and writes the ones that fail to
+// a spool directory.
+type spoolPublisher struct {
+	p       messagePublisher
+	dir     string
+	project string
+	topic   string
+}
+
+func newSpoolPublisher(p messagePublisher, cfg *config) (*spoolPublisher, error) {
+	if err := os.MkdirAll(cfg.spoolDir, 0755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll(%q): %v", cfg.spoolDir, err)
+	}
+	return &spoolPublisher{p: p, dir: cfg.spoolDir, project: cfg.projectID, topic: cfg.topicID}, nil
+}
+
+// spooledError is returned by spoolPublisher when a message couldn't be
+// published, but was saved for `flakybot flush`. The message wasn't delivered
+// yet, so it mustn't be treated as published.
+type spooledError struct {
+	path string
+	err  error
+}
+
+func (e *spooledError) Error() string {
+	return fmt.Sprintf("saved to %s for `flakybot flush`: %v", e.path, e.err)
+}
+
+func (e *spooledError) Unwrap() error { return e.err }
+
+// publish returns a *spooledError if msg couldn't be published, but was
+// spooled. Only transient errors are spooled: flush could never publish a
+// message that was denied or invalid, so those are returned as they are.
+func (s *spoolPublisher) publish(ctx context.Context, msg *pubsub.Message) (serverID string, err error) {
+	id, err := s.p.publish(ctx, msg)
+	if err == nil {
+		return id, nil
+	}
+	if !retryable(err) {
+		return "", err
+	}
+	path, spoolErr := spool(s.dir, &spooledMessage{
+		Project:     s.project,
+		Topic:       s.topic,
+		Data:        msg.Data,
+		Attributes:  msg.Attributes,
+		OrderingKey: msg.OrderingKey,
+	})
+	if spoolErr != nil {
+		return "", fmt.Errorf("%v (and spooling it failed: %v)", err, spoolErr)
+	}
+	log.Printf("Could not publish, so saved the message to %s for `flakybot flush`: %v", path, err)
+	return "", &spooledError{path: path, err: err}
+}
+
+// spool writes m to dir and returns its path. The file is named after the
+// hash of its contents, so spooling the same message twice only keeps one
+// copy.
+func spool(dir string, m *spooledMessage) (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+	// Write to a temporary file first, so flush never sees half a message.
+	tmp, err := os.CreateTemp(dir, ".spool-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+// flushMain runs the flush command, which publishes the messages in a spool
+// directory. It returns the exit code.
+func flushMain(args []string) int {
+	fs := flag.NewFlagSet("flush", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: flakybot flush -spool_dir=DIR [flags]
+
+Flush publishes the messages saved in --spool_dir by earlier runs that couldn't
+reach Pub/Sub, to the project and topic they were meant for. Each file is
+deleted once Pub/Sub acknowledges it, so it's safe to run flush again after a
+failure.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	spoolDir := fs.String("spool_dir", "", "Directory of messages to publish.")
+	serviceAccount := fs.String("service_account", "", "Path to service account to use instead of client library auto-detection.")
+	pubsubEndpoint := fs.String("pubsub_endpoint", "", "Pub/Sub endpoint to publish to without TLS or credentials, like a local emulator. Defaults to the PUBSUB_EMULATOR_HOST environment variable.")
+	creds := &credentialFlags{}
+	creds.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *spoolDir == "" {
+		log.Print("--spool_dir is required")
+		return exitConfigError
+	}
+	// Check the credential flags once, rather than for every topic.
+	if err := creds.apply(&config{serviceAccount: *serviceAccount}); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	publishers := map[string]messagePublisher{}
+	publisherFor := func(project, topic string) (messagePublisher, error) {
+		key := project + "/" + topic
+		if p, ok := publishers[key]; ok {
+			return p, nil
+		}
+		cfg := &config{
+			projectID:      project,
+			topicID:        topic,
+			serviceAccount: *serviceAccount,
+			pubsubEndpoint: firstNonEmpty(*pubsubEndpoint, getenv("PUBSUB_EMULATOR_HOST")),
+		}
+		if err := creds.apply(cfg); err != nil {
+			return nil, err
+		}
+		pub, err := pubSubPublisher(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		p := newRetryPublisher(pub, defaultRetryPolicy)
+		publishers[key] = p
+		return p, nil
+	}
+	n, err := flushSpool(ctx, *spoolDir, publisherFor)
+	log.Printf("Flushed %d messages from %s.", n, *spoolDir)
+	if err != nil {
+		log.Printf("Could not flush: %v", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// flushSpool publishes every message in dir with the publisher for its project
+// and topic, and deletes the ones that are published. It keeps going after a
+// message fails, and returns how many were published.
+func flushSpool(ctx context.Context, dir string, publisherFor func(project, topic string) (messagePublisher, error)) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	n := 0
+	var failed []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := flushFile(ctx, path, publisherFor); err != nil {
+			log.Printf("Error flushing %s: %v", path, err)
+			failed = append(failed, name)
+			continue
+		}
+		n++
+	}
+	if len(failed) > 0 {
+		return n, fmt.Errorf("%d of %d messages failed: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return n, nil
+}
+
+func flushFile(ctx context.Context, path string, publisherFor func(project, topic string) (messagePublisher, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m := &spooledMessage{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	if m.Project == "" || m.Topic == "" {
+		return fmt.Errorf("no project or topic")
+	}
+	p, err := publisherFor(m.Project, m.Topic)
+	if err != nil {
+		return err
+	}
+	id, err := p.publish(ctx, &pubsub.Message{Data: m.Data, Attributes: m.Attributes, OrderingKey: m.OrderingKey})
+	if err != nil {
+		return err
+	}
+	log.Printf("Published %s to projects/%s/topics/%s (%v)!", path, m.Project, m.Topic, id)
+	// The message was acknowledged. If this fails, the next flush publishes it
+	// again, which the bot handles like a repeated build.
+	return os.Remove(path)
+}
@@ -0,0 +1,207 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSpoolAndFlush(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	cfg := &config{projectID: "my-project", topicID: "my-topic", spoolDir: dir}
+	down := &fakePublisher{fail: func(m *pubsub.Message) bool {
+		return string(m.Data) != "ok"
+	}, err: status.Error(codes.Unavailable, "unavailable")}
+	s, err := newSpoolPublisher(down, cfg)
+	if err != nil {
+		t.Fatalf("newSpoolPublisher: %v", err)
+	}
+	msgs := []*pubsub.Message{
+		{Data: []byte("ok")},
+		{Data: []byte("one"), Attributes: map[string]string{"a": "b"}},
+		{Data: []byte("two"), OrderingKey: "key"},
+		// Spooling the same message again doesn't make another file.
+		{Data: []byte("two"), OrderingKey: "key"},
+	}
+	for _, m := range msgs {
+		_, err := s.publish(context.Background(), m)
+		var se *spooledError
+		if spooled := errors.As(err, &se); spooled != (string(m.Data) != "ok") {
+			t.Errorf("publish(%s) got error %v, want a *spooledError only if it failed", m.Data, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir: %v", err)
+	}
+	if got := len(entries); got != 2 {
+		t.Fatalf("spooled %d files, want 2", got)
+	}
+
+	// A file flush can't parse is left alone.
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte("{"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	up := &fakePublisher{}
+	var topics []string
+	publisherFor := func(project, topic string) (messagePublisher, error) {
+		topics = append(topics, project+"/"+topic)
+		return up, nil
+	}
+	n, err := flushSpool(context.Background(), dir, publisherFor)
+	if err == nil {
+		t.Errorf("flushSpool got nil error, want an error for %s", bad)
+	}
+	if n != 2 {
+		t.Errorf("flushSpool flushed %d messages, want 2", n)
+	}
+	sort.Strings(up.called)
+	if diff := cmp.Diff(up.called, []string{"one", "two"}); diff != "" {
+		t.Errorf("flushSpool published diff (-got, +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(topics, []string{"my-project/my-topic", "my-project/my-topic"}); diff != "" {
+		t.Errorf("flushSpool publishers diff (-got, +want):\n%s", diff)
+	}
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "bad.json" {
+		t.Errorf("flushSpool left %v, want only bad.json", entries)
+	}
+
+	// Flushing again doesn't publish anything again.
+	os.Remove(bad)
+	if n, err := flushSpool(context.Background(), dir, publisherFor); n != 0 || err != nil {
+		t.Errorf("second flushSpool = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestSpoolSkipsPermanentErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	cfg := &config{projectID: "my-project", topicID: "my-topic", spoolDir: dir}
+	denied := status.Error(codes.PermissionDenied, "denied")
+	s, err := newSpoolPublisher(&fakePublisher{errs: []error{denied}}, cfg)
+	if err != nil {
+		t.Fatalf("newSpoolPublisher: %v", err)
+	}
+	_, err = s.publish(context.Background(), &pubsub.Message{Data: []byte("data")})
+	var se *spooledError
+	if errors.As(err, &se) || status.Code(err) != codes.PermissionDenied {
+		t.Errorf("publish got error %v, want the PermissionDenied error, not spooled", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("spooled %d files (err %v), want 0", len(entries), err)
+	}
+}
+
+func TestFlushKeepsFailedMessages(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	path, err := spool(dir, &spooledMessage{Project: "p", Topic: "t", Data: []byte("data")})
+	if err != nil {
+		t.Fatalf("spool: %v", err)
+	}
+	failing := &fakePublisher{errs: []error{errors.New("still down")}}
+	publisherFor := func(project, topic string) (messagePublisher, error) { return failing, nil }
+	if _, err := flushSpool(context.Background(), dir, publisherFor); err == nil {
+		t.Errorf("flushSpool got nil error, want an error")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("flushSpool removed a message that failed to publish: %v", err)
+	}
+	if n, err := flushSpool(context.Background(), dir, publisherFor); n != 1 || err != nil {
+		t.Errorf("second flushSpool = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("flushSpool didn't remove the published message: %v", err)
+	}
+}
+
+func TestPublishDoesNotRecordSpooledReports(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	logs := writeReports(t, dir, map[string]string{
+		"a/sponge_log.xml": "<testsuites>a</testsuites>",
+	})
+	ledgerPath := filepath.Join(dir, "ledger")
+	l, err := openLedger(ledgerPath)
+	if err != nil {
+		t.Fatalf("openLedger: %v", err)
+	}
+	cfg := &config{
+		installationID: "123",
+		repo:           "googleapis/repo-automation-bots",
+		commit:         "abc123",
+		buildURL:       "https://example.com",
+		formats:        []*reportFormat{formatByName("sponge")},
+		parallelism:    1,
+		projectID:      "my-project",
+		topicID:        "my-topic",
+		spoolDir:       filepath.Join(dir, "spool"),
+		ledger:         l,
+	}
+	down := &fakePublisher{fail: func(*pubsub.Message) bool { return true }, err: status.Error(codes.Unavailable, "unavailable")}
+	s, err := newSpoolPublisher(down, cfg)
+	if err != nil {
+		t.Fatalf("newSpoolPublisher: %v", err)
+	}
+	results, err := publish(context.Background(), cfg, s, logs)
+	var pe *publishError
+	if !errors.As(err, &pe) || len(pe.spooled) != 1 {
+		t.Errorf("publish got error %v, want a *publishError with 1 spooled file", err)
+	}
+	if got := len(results[0].spooled); got != 1 {
+		t.Errorf("publish spooled %d messages, want 1", got)
+	}
+	if got := newRunSummary(cfg, results).Files[0].Status; got != statusSpooled {
+		t.Errorf("summary status is %q, want %q", got, statusSpooled)
+	}
+	if got := publishExitCode(results, err, policyFail); got != exitPublishFailed {
+		t.Errorf("publishExitCode = %d, want %d", got, exitPublishFailed)
+	}
+
+	// The report wasn't delivered, so the next run mustn't skip it.
+	key := idempotencyKey(cfg.repo, cfg.commit, contentHash([]byte("<testsuites>a</testsuites>")))
+	if l.has(key) {
+		t.Errorf("ledger has the key of a spooled report")
+	}
+	if l, err := openLedger(ledgerPath); err != nil || l.has(key) {
+		t.Errorf("openLedger got the key of a spooled report (err %v)", err)
+	}
+}
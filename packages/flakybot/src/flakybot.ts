@@ -17,6 +17,8 @@
  *
  * The input payload should include:
  *  - xunitXML: the base64 encoded xUnit XML log.
+ *  - xunitEncoding: how xunitXML is encoded, base64 (the default) or
+ *    gzip+base64.
  *  - commit: the commit hash the build was for.
  *  - buildURL: URL to link to for a build.
  *  - repo: the repo being tested (e.g. GoogleCloudPlatform/golang-samples).
@@ -25,6 +27,7 @@
 // eslint-disable-next-line node/no-extraneous-import
 import {Probot, Logger} from 'probot';
 import xmljs from 'xml-js';
+import {gunzipSync} from 'zlib';
 import {DatastoreLock} from '@google-automations/datastore-lock';
 // eslint-disable-next-line node/no-extraneous-import
 import {Octokit} from '@octokit/rest';
@@ -134,6 +137,7 @@ export interface FlakyBotPayload {
   buildURL: string;
 
   xunitXML?: string; // Base64 encoded to avoid JSON escaping issues. Fill in to get separate issues for separate tests.
+  xunitEncoding?: string; // How xunitXML is encoded: base64 (the default) or gzip+base64.
   testsFailed?: boolean; // Whether the entire build failed. Ignored if xunitXML is set.
 }
 
@@ -225,10 +229,11 @@ export function flakybot(app: Probot) {
 
     let results: TestResults;
     if (typedContext.payload.xunitXML) {
-      const xml = Buffer.from(
-        typedContext.payload.xunitXML,
-        'base64'
-      ).toString();
+      let data = Buffer.from(typedContext.payload.xunitXML, 'base64');
+      if (typedContext.payload.xunitEncoding === 'gzip+base64') {
+        data = gunzipSync(data);
+      }
+      const xml = data.toString();
       results = flakybot.findTestResults(xml);
     } else {
       if (typedContext.payload.testsFailed === undefined) {
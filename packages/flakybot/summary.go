@@ -0,0 +1,267 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+// summaryGitHub is the --summary format for GitHub Actions step summaries.
+const summaryGitHub = "github"
+
+// The status of a file in a summary.
+const (
+	statusPublished = "published"
+	statusDryRun    = "dry_run"
+	statusSkipped   = "skipped"
+	statusSpooled   = "spooled"
+	statusFailed    = "failed"
+)
+
+// testCounts counts the test cases in a report. Errors count as failures, and
+// skipped tests count as tests.
+type testCounts struct {
+	Tests    int `json:"tests"`
+	Failures int `json:"failures"`
+	Skips    int `json:"skips"`
+}
+
+func countTests(xml []byte) (*testCounts, error) {
+	suites, err := xunit.Parse(xml)
+	if err != nil {
+		return nil, err
+	}
+	c := &testCounts{}
+	for _, s := range suites {
+		for _, tc := range s.Cases {
+			c.Tests++
+			switch {
+			case tc.Skipped != nil:
+				c.Skips++
+			case tc.Failure != nil || tc.Error != nil:
+				c.Failures++
+			}
+		}
+	}
+	return c, nil
+}
+
+// runSummary is the outcome of a publish run, for --summary_json and
+// --summary.
+type runSummary struct {
+	DryRun   bool `json:"dryRun"`
+	ExitCode int  `json:"exitCode"`
+	// Error is why the run failed, like a bad flag or no test reports, even
+	// if --on_empty or --on_publish_error let it exit successfully.
+	Error string `json:"error,omitempty"`
+	// SkipReason is why nothing was published, like a presubmit build.
+	SkipReason string              `json:"skipReason,omitempty"`
+	Config     map[string]envValue `json:"config"`
+	Files      []*fileSummary      `json:"files"`
+	Totals     summaryTotals       `json:"totals"`
+}
+
+// fileSummary is the outcome of a single file.
+type fileSummary struct {
+	// Path is relative to the logs dir.
+	Path string `json:"path"`
+	// Status is one of the status constants.
+	Status     string   `json:"status"`
+	MessageIDs []string `json:"messageIDs,omitempty"`
+	// SpooledTo are the files messages were saved to for `flakybot flush`.
+	SpooledTo []string `json:"spooledTo,omitempty"`
+	Messages  int      `json:"messages"`
+	Bytes     int      `json:"bytes"`
+	// Counts is left out if the report couldn't be parsed.
+	Counts     *testCounts `json:"counts,omitempty"`
+	SkipReason string      `json:"skipReason,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// summaryTotals adds up the files. The test counts leave out skipped files,
+// since they're duplicates.
+type summaryTotals struct {
+	Files     int `json:"files"`
+	Published int `json:"published"`
+	Skipped   int `json:"skipped"`
+	Spooled   int `json:"spooled"`
+	Failed    int `json:"failed"`
+	testCounts
+}
+
+func newRunSummary(cfg *config, results []*fileResult) *runSummary {
+	s := &runSummary{DryRun: cfg.dryRun, Config: map[string]envValue{}, Files: []*fileSummary{}}
+	for _, r := range cfg.configRows() {
+		if r.value != "" {
+			s.Config[r.name] = envValue{Value: r.value, Source: r.source}
+		}
+	}
+	for _, r := range results {
+		f := &fileSummary{
+			Path:       reportName(cfg.logsDir, r.path),
+			MessageIDs: r.ids,
+			SpooledTo:  r.spooled,
+			Messages:   r.messages,
+			Bytes:      r.bytes,
+			Counts:     r.counts,
+			SkipReason: r.skipped,
+		}
+		s.Totals.Files++
+		switch {
+		case r.err != nil:
+			f.Status = statusFailed
+			f.Error = r.err.Error()
+			s.Totals.Failed++
+		case len(r.spooled) > 0:
+			f.Status = statusSpooled
+			s.Totals.Spooled++
+		case r.skipped != "":
+			f.Status = statusSkipped
+			s.Totals.Skipped++
+		case cfg.dryRun:
+			f.Status = statusDryRun
+			s.Totals.Published++
+		default:
+			f.Status = statusPublished
+			s.Totals.Published++
+		}
+		if r.counts != nil && r.skipped == "" {
+			s.Totals.Tests += r.counts.Tests
+			s.Totals.Failures += r.counts.Failures
+			s.Totals.Skips += r.counts.Skips
+		}
+		s.Files = append(s.Files, f)
+	}
+	return s
+}
+
+// writeSummaries writes s to jsonPath, if it's set, and in the given format.
+// Failing to write a summary is logged, but doesn't change the exit code.
+func writeSummaries(cfg *config, jsonPath, format string, s *runSummary) {
+	if jsonPath != "" {
+		if err := writeSummaryJSON(jsonPath, s); err != nil {
+			log.Printf("Could not write --summary_json: %v", err)
+		}
+	}
+	if format == summaryGitHub {
+		path := getenv("GITHUB_STEP_SUMMARY")
+		if path == "" {
+			log.Print("Not writing a step summary: GITHUB_STEP_SUMMARY isn't set. Is this a GitHub Actions job?")
+			return
+		}
+		if err := appendFile(path, func(w io.Writer) error { return writeGitHubSummary(w, cfg, s) }); err != nil {
+			log.Printf("Could not write the step summary: %v", err)
+		}
+	}
+}
+
+func writeSummaryJSON(path string, s *runSummary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// appendFile calls write with path opened for appending. Other steps in a
+// GitHub Actions job write to the same step summary file.
+func appendFile(path string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeGitHubSummary writes s as GitHub flavored markdown.
+func writeGitHubSummary(w io.Writer, cfg *config, s *runSummary) error {
+	var b strings.Builder
+	b.WriteString("### Flaky Bot\n\n")
+	if s.Error != "" {
+		fmt.Fprintf(&b, "**Error:** %s\n\n", markdownCell(s.Error))
+	}
+	if s.SkipReason != "" {
+		fmt.Fprintf(&b, "Nothing was published: %s.\n\n", markdownCell(s.SkipReason))
+	}
+	verb := "Published"
+	if s.DryRun {
+		verb = "Dry run: would have published"
+	}
+	fmt.Fprintf(&b, "%s %d of %d reports for %s at %s", verb, s.Totals.Published, s.Totals.Files, markdownCode(cfg.repo), markdownCode(cfg.commit))
+	if s.Totals.Spooled > 0 {
+		fmt.Fprintf(&b, " (%d skipped, %d spooled, %d failed)", s.Totals.Skipped, s.Totals.Spooled, s.Totals.Failed)
+	} else if s.Totals.Skipped > 0 || s.Totals.Failed > 0 {
+		fmt.Fprintf(&b, " (%d skipped, %d failed)", s.Totals.Skipped, s.Totals.Failed)
+	}
+	fmt.Fprintf(&b, ": %d tests, %d failed, %d skipped.\n\n", s.Totals.Tests, s.Totals.Failures, s.Totals.Skips)
+
+	if len(s.Files) > 0 {
+		b.WriteString("| Report | Result | Tests | Failed | Skipped | Messages | Size |\n")
+		b.WriteString("| --- | --- | ---: | ---: | ---: | ---: | ---: |\n")
+		for _, f := range s.Files {
+			result := f.Status
+			switch f.Status {
+			case statusSkipped:
+				result += ": " + f.SkipReason
+			case statusSpooled:
+				result += " for flakybot flush"
+			case statusFailed:
+				result += ": " + f.Error
+			}
+			tests, failures, skips := "-", "-", "-"
+			if c := f.Counts; c != nil {
+				tests, failures, skips = fmt.Sprint(c.Tests), fmt.Sprint(c.Failures), fmt.Sprint(c.Skips)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %d | %d B |\n", markdownCode(f.Path), markdownCell(result), tests, failures, skips, f.Messages, f.Bytes)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("<details><summary>Config</summary>\n\n")
+	b.WriteString("| Setting | Value | Source |\n| --- | --- | --- |\n")
+	for _, r := range cfg.configRows() {
+		if r.value == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", r.name, markdownCell(r.value), markdownCell(r.source))
+	}
+	b.WriteString("\n</details>\n\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes s for a cell of a markdown table.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return "`" + markdownCell(strings.ReplaceAll(s, "`", "'")) + "`"
+}
@@ -0,0 +1,233 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const summaryReport = `<testsuites><testsuite name="pkg">
+<testcase name="TestPass"></testcase>
+<testcase name="TestFail"><failure>boom</failure></testcase>
+<testcase name="TestError"><error>boom</error></testcase>
+<testcase name="TestSkip"><skipped/></testcase>
+</testsuite></testsuites>`
+
+func TestCountTests(t *testing.T) {
+	got, err := countTests([]byte(summaryReport))
+	if err != nil {
+		t.Fatalf("countTests: %v", err)
+	}
+	want := &testCounts{Tests: 4, Failures: 2, Skips: 1}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("countTests diff (-got, +want):\n%s", diff)
+	}
+	if _, err := countTests([]byte("<testsuites>")); err == nil {
+		t.Errorf("countTests with bad XML got no error, want one")
+	}
+}
+
+func TestPublishMainSummary(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	logsDir := filepath.Join(dir, "logs")
+	writeReports(t, logsDir, map[string]string{
+		"a/sponge_log.xml":    summaryReport,
+		"copy/sponge_log.xml": summaryReport,
+		"b/sponge_log.xml":    "not xml",
+	})
+	stepSummary := filepath.Join(dir, "step_summary.md")
+	if err := os.WriteFile(stepSummary, []byte("From an earlier step.\n"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	getenv = fakeEnv{"GITHUB_STEP_SUMMARY": stepSummary}.get
+	defer func() { getenv = os.Getenv }()
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+
+	summaryPath := filepath.Join(dir, "summary.json")
+	args := []string{
+		"-logs_dir", logsDir,
+		"-repo", "googleapis/repo-automation-bots",
+		"-commit_hash", "abc123",
+		"-build_url", "local",
+		"-parallelism", "1",
+		"-out_dir", filepath.Join(dir, "out"),
+		"-summary_json", summaryPath,
+		"-summary", "github",
+	}
+	if got := run(args); got != 0 {
+		t.Fatalf("run got exit code %d, want 0", got)
+	}
+
+	data, err := os.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	s := &runSummary{}
+	if err := json.Unmarshal(data, s); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if !s.DryRun {
+		t.Errorf("summary has dryRun=false, want true for --out_dir")
+	}
+	if got := s.Config["repo"].Value; got != "googleapis/repo-automation-bots" {
+		t.Errorf("summary has repo %q in the config, want googleapis/repo-automation-bots", got)
+	}
+	got := map[string]string{}
+	for _, f := range s.Files {
+		got[f.Path] = f.Status
+		if f.Status == statusDryRun && (f.Messages != 1 || f.Bytes == 0 || len(f.MessageIDs) != 1) {
+			t.Errorf("summary of %s has %d messages, %d bytes, and IDs %v, want 1 message with an ID", f.Path, f.Messages, f.Bytes, f.MessageIDs)
+		}
+	}
+	// The reports are published in order, so the copy is the one skipped.
+	want := map[string]string{
+		"a/sponge_log.xml":    statusDryRun,
+		"b/sponge_log.xml":    statusDryRun,
+		"copy/sponge_log.xml": statusSkipped,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("summary file statuses diff (-got, +want):\n%s", diff)
+	}
+	wantTotals := summaryTotals{
+		Files:      3,
+		Published:  2,
+		Skipped:    1,
+		testCounts: testCounts{Tests: 4, Failures: 2, Skips: 1},
+	}
+	if diff := cmp.Diff(s.Totals, wantTotals, cmp.AllowUnexported(summaryTotals{})); diff != "" {
+		t.Errorf("summary totals diff (-got, +want):\n%s", diff)
+	}
+
+	md, err := os.ReadFile(stepSummary)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	for _, want := range []string{
+		"From an earlier step.\n### Flaky Bot\n",
+		"Dry run: would have published 2 of 3 reports for `googleapis/repo-automation-bots` at `abc123` (1 skipped, 0 failed): 4 tests, 2 failed, 1 skipped.",
+		"| `a/sponge_log.xml` | dry_run | 4 | 2 | 1 | 1 |",
+		"| `b/sponge_log.xml` | dry_run | - | - | - | 1 |",
+		"| `copy/sponge_log.xml` | skipped: identical to ",
+		"| repo | googleapis/repo-automation-bots | flag |",
+	} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("step summary doesn't contain %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestMarkdownCell(t *testing.T) {
+	if got, want := markdownCell("a | b\nc"), `a \| b c`; got != want {
+		t.Errorf("markdownCell got %q, want %q", got, want)
+	}
+}
+
+func TestPublishMainSummaryWhenNotPublishing(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+
+	logsDir := t.TempDir()
+	writeReports(t, logsDir, map[string]string{"a/sponge_log.xml": summaryReport})
+	tests := []struct {
+		name           string
+		args           []string
+		wantCode       int
+		wantError      string
+		wantSkipReason string
+	}{
+		{
+			name:           "presubmit",
+			args:           []string{"-mode", "presubmit"},
+			wantCode:       exitOK,
+			wantSkipReason: "--allow_presubmit",
+		},
+		{
+			name:      "transport failure",
+			args:      []string{"-transport", "http", "-http_url", "ftp://example.com"},
+			wantCode:  exitConfigError,
+			wantError: "--http_url must be an http or https URL",
+		},
+		{
+			name:      "no reports",
+			args:      []string{"-logs_dir", t.TempDir()},
+			wantCode:  exitNoReports,
+			wantError: "no test reports found",
+		},
+		{
+			name:      "no reports with --on_empty=warn",
+			args:      []string{"-logs_dir", t.TempDir(), "-on_empty", "warn"},
+			wantCode:  exitOK,
+			wantError: "no test reports found",
+		},
+		{
+			name:      "bad flag value",
+			args:      []string{"-parallelism", "0"},
+			wantCode:  exitConfigError,
+			wantError: "--parallelism must be at least 1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getenv = fakeEnv{"FLAKYBOT_HTTP_SECRET": "secret"}.get
+			defer func() { getenv = os.Getenv }()
+
+			summaryPath := filepath.Join(t.TempDir(), "summary.json")
+			args := append([]string{
+				"-logs_dir", logsDir,
+				"-repo", "googleapis/repo-automation-bots",
+				"-commit_hash", "abc123",
+				"-build_url", "local",
+				"-summary_json", summaryPath,
+			}, test.args...)
+			if got := run(args); got != test.wantCode {
+				t.Errorf("run got exit code %d, want %d", got, test.wantCode)
+			}
+			data, err := os.ReadFile(summaryPath)
+			if err != nil {
+				t.Fatalf("os.ReadFile: %v", err)
+			}
+			s := &runSummary{}
+			if err := json.Unmarshal(data, s); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			if s.ExitCode != test.wantCode {
+				t.Errorf("summary has exitCode %d, want %d", s.ExitCode, test.wantCode)
+			}
+			if !strings.Contains(s.Error, test.wantError) || (test.wantError == "") != (s.Error == "") {
+				t.Errorf("summary has error %q, want it to contain %q", s.Error, test.wantError)
+			}
+			if !strings.Contains(s.SkipReason, test.wantSkipReason) || (test.wantSkipReason == "") != (s.SkipReason == "") {
+				t.Errorf("summary has skipReason %q, want it to contain %q", s.SkipReason, test.wantSkipReason)
+			}
+			if len(s.Files) != 0 {
+				t.Errorf("summary has %d files, want 0", len(s.Files))
+			}
+		})
+	}
+}
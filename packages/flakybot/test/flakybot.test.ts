@@ -22,6 +22,7 @@ import {Octokit} from '@octokit/rest';
 import snapshot from 'snap-shot-it';
 import nock from 'nock';
 import * as fs from 'fs';
+import {gzipSync} from 'zlib';
 import * as assert from 'assert';
 import {describe, it, beforeEach} from 'mocha';
 import * as sinon from 'sinon';
@@ -623,6 +624,49 @@ describe('flakybot', () => {
         scopes.forEach(s => s.done());
       });
 
+      it('opens the same issue for gzip+base64 XML as for base64', async () => {
+        const bodies: Array<{}> = [];
+        for (const compress of [false, true]) {
+          nockRepo('golang-samples', false);
+          getConfigWithDefaultStub.resolves(DEFAULT_CONFIG);
+          const plain = buildPayload('one_failed.xml', 'golang-samples');
+          const payload = compress
+            ? {
+                ...plain,
+                xunitXML: gzipSync(
+                  Buffer.from(plain.xunitXML, 'base64')
+                ).toString('base64'),
+                xunitEncoding: 'gzip+base64',
+              }
+            : plain;
+
+          const scopes = [
+            nockIssues('golang-samples'),
+            nock('https://api.github.com')
+              .post(
+                '/repos/GoogleCloudPlatform/golang-samples/issues',
+                body => {
+                  bodies.push(body);
+                  return true;
+                }
+              )
+              .reply(200),
+          ];
+
+          await probot.receive({
+            // eslint-disable-next-line @typescript-eslint/no-explicit-any
+            name: 'pubsub.message' as any,
+            // eslint-disable-next-line @typescript-eslint/no-explicit-any
+            payload: payload as any,
+            id: 'abc123',
+          });
+
+          scopes.forEach(s => s.done());
+        }
+        assert.strictEqual(bodies.length, 2);
+        assert.deepStrictEqual(bodies[1], bodies[0]);
+      });
+
       it('opens an issue [Python]', async () => {
         nockRepo('python-docs-samples', false);
         getConfigWithDefaultStub.resolves(DEFAULT_CONFIG);
@@ -0,0 +1,154 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/googleapis/repo-automation-bots/packages/flakybot/xunit"
+)
+
+// validateMain runs the validate command, which checks the config can be
+// detected and every report can be parsed and published, without publishing
+// anything. It returns the exit code.
+func validateMain(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: flakybot validate [flags]
+
+Validate detects the config and finds test reports like publish does, then
+checks every report can be parsed and turned into messages. Nothing is sent
+to Pub/Sub. It exits with an error if anything publish needs is missing.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	cf := &configFlags{}
+	cf.register(fs)
+	find := &findFlags{}
+	find.register(fs)
+	st := &settings{}
+	st.register(fs)
+	maxMessageBytes := fs.Int("max_message_bytes", defaultMaxMessageBytes, "Maximum size of a message. Bigger reports are split into several messages.")
+	compress := fs.Bool("compress", false, "Gzip the xUnit XML before base64 encoding it, to make messages smaller.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg := &config{maxMessageBytes: *maxMessageBytes, compress: *compress}
+	if err := st.load(fs, cfg); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	if err := cf.apply(cfg, st); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	if err := find.apply(cfg, st); err != nil {
+		log.Print(err)
+		return exitConfigError
+	}
+	ok := cfg.setDefaults()
+	if st.verbose {
+		logConfig(cfg)
+	}
+	if ok {
+		log.Printf("Config OK: repo=%s installation_id=%s commit=%s mode=%s.", cfg.repo, cfg.installationID, cfg.commit, cfg.mode)
+	}
+
+	logs, err := findLogs(cfg)
+	if err != nil {
+		log.Printf("Error searching for logs: %v", err)
+		return exitError
+	}
+	if len(logs) == 0 {
+		logNoReports(cfg)
+		return exitError
+	}
+	var results []*reportCheck
+	for _, path := range logs {
+		results = append(results, checkReport(cfg, path))
+	}
+	if err := writeReportChecks(os.Stdout, cfg.logsDir, results); err != nil {
+		log.Printf("Error writing results: %v", err)
+		return exitError
+	}
+	for _, r := range results {
+		if r.err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		return exitError
+	}
+	return exitOK
+}
+
+// reportCheck is the result of checking a single report.
+type reportCheck struct {
+	path     string
+	tests    int
+	failures int
+	messages int
+	err      error
+}
+
+// checkReport reads, parses, and builds the messages for the report at path.
+func checkReport(cfg *config, path string) *reportCheck {
+	r := &reportCheck{path: path}
+	data, err := readReport(cfg, path)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	results, err := xunit.FindTestResults(data)
+	if err != nil {
+		r.err = fmt.Errorf("parsing: %v", err)
+		return r
+	}
+	r.tests = len(results.Passes) + len(results.Failures)
+	r.failures = len(results.Failures)
+	msgs, err := buildMessages(cfg, data, contentHash(data))
+	if err != nil {
+		r.err = fmt.Errorf("building messages: %v", err)
+		return r
+	}
+	r.messages = len(msgs)
+	return r
+}
+
+func writeReportChecks(w io.Writer, logsDir string, checks []*reportCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tTESTS\tFAILED\tMESSAGES\tRESULT")
+	for _, c := range checks {
+		file := c.path
+		if rel, err := filepath.Rel(logsDir, c.path); err == nil {
+			file = rel
+		}
+		if c.err != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\terror: %v\n", file, c.err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\tok\n", file, c.tests, c.failures, c.messages)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckReport(t *testing.T) {
+	cfg := &config{
+		logsDir: "test/fixtures/testdata",
+		formats: []*reportFormat{formatByName("junit")},
+	}
+	checks := []*reportCheck{
+		checkReport(cfg, "test/fixtures/testdata/node_group.xml"),
+		checkReport(cfg, "test/fixtures/testdata/config.yaml"),
+	}
+	if got := checks[0]; got.err != nil || got.failures != 24 || got.messages != 1 {
+		t.Errorf("checkReport(node_group.xml) got %+v, want 24 failures in 1 message", got)
+	}
+	if checks[1].err == nil {
+		t.Errorf("checkReport(config.yaml) got no error, want an error")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeReportChecks(buf, cfg.logsDir, checks); err != nil {
+		t.Fatalf("writeReportChecks: %v", err)
+	}
+	for _, want := range []string{"node_group.xml", "ok", "config.yaml", "error:"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("writeReportChecks got:\n%s\nwant it to contain %q", buf.String(), want)
+		}
+	}
+}
+
+func TestValidateMain(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	getenv = fakeEnv{}.get
+	defer func() { getenv = os.Getenv }()
+	gitStartDir = t.TempDir()
+	defer func() { gitStartDir = "." }()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sponge_log.xml"), []byte(`<testsuites><testsuite name="pkg"><testcase name="TestOK"></testcase></testsuite></testsuites>`), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	flags := []string{"-logs_dir", dir, "-repo", "googleapis/repo-automation-bots", "-commit_hash", "abc123", "-build_url", "local"}
+	if got := validateMain(flags); got != 0 {
+		t.Errorf("validateMain got exit code %d, want 0", got)
+	}
+	// Without a commit, the config isn't valid.
+	if got := validateMain(flags[:len(flags)-4]); got != 1 {
+		t.Errorf("validateMain without --commit_hash got exit code %d, want 1", got)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad_sponge_log.xml"), []byte("<testsuites>"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if got := validateMain(flags); got != 1 {
+		t.Errorf("validateMain with a bad report got exit code %d, want 1", got)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// signatureHeader has the HMAC-SHA256 of the request body, keyed with the
+// shared secret, as sha256=<hex>. It's the same scheme as GitHub's
+// X-Hub-Signature-256, so receivers can reuse the same verification code.
+const signatureHeader = "X-Flakybot-Signature-256"
+
+// webhookPublisher POSTs messages to a URL, for setups without Pub/Sub
+// credentials, like forks.
+type webhookPublisher struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func newWebhookPublisher(u string, secret []byte) (*webhookPublisher, error) {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, fmt.Errorf("--http_url must be an http or https URL, got %q", u)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("no secret to sign requests with; set --http_secret_file or FLAKYBOT_HTTP_SECRET")
+	}
+	return &webhookPublisher{url: u, secret: secret, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// readWebhookSecret returns the contents of path, or env (the
+// FLAKYBOT_HTTP_SECRET environment variable) if path isn't set.
+func readWebhookSecret(path, env string) ([]byte, error) {
+	if path == "" {
+		return []byte(env), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
+
+// sign returns the value of the signature header for body.
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// publish POSTs the message JSON. The server ID is the id field of the JSON
+// response, if there is one.
+func (w *webhookPublisher) publish(ctx context.Context, msg *pubsub.Message) (serverID string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(msg.Data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "flakybot")
+	req.Header.Set(signatureHeader, sign(w.secret, msg.Data))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		// Connection errors are usually transient.
+		return "", &webhookError{err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", &webhookError{err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &webhookError{status: resp.StatusCode, err: fmt.Errorf("POST %s: %s: %s", w.url, resp.Status, strings.TrimSpace(string(body)))}
+	}
+	var ack struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(body, &ack) == nil && ack.ID != "" {
+		return ack.ID, nil
+	}
+	return resp.Status, nil
+}
+
+// webhookError is a failed webhook request. status is 0 if there was no
+// response.
+type webhookError struct {
+	status int
+	err    error
+}
+
+func (e *webhookError) Error() string {
+	return e.err.Error()
+}
+
+// retryable reports whether the request is worth retrying: there was no
+// response, or the server was overloaded or failed.
+func (e *webhookError) retryable() bool {
+	return e.status == 0 || e.status == http.StatusTooManyRequests || e.status == http.StatusRequestTimeout || e.status >= 500
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// fakeWebhook is a webhook receiver that checks signatures and fails the
+// first failures requests with status.
+type fakeWebhook struct {
+	secret   []byte
+	status   int
+	failures int
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := r.Header.Get(signatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "want a JSON POST", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "try again", f.status)
+		return
+	}
+	f.bodies = append(f.bodies, string(body))
+	w.Write([]byte(`{"id": "webhook-1"}`))
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	secret := []byte("s3cret")
+	tests := []struct {
+		name     string
+		hook     *fakeWebhook
+		secret   []byte
+		wantErr  bool
+		wantBody bool
+	}{
+		{name: "ok", hook: &fakeWebhook{}, secret: secret, wantBody: true},
+		{name: "retries server errors", hook: &fakeWebhook{status: http.StatusServiceUnavailable, failures: 2}, secret: secret, wantBody: true},
+		{name: "retries rate limits", hook: &fakeWebhook{status: http.StatusTooManyRequests, failures: 1}, secret: secret, wantBody: true},
+		{name: "gives up on client errors", hook: &fakeWebhook{status: http.StatusBadRequest, failures: 1}, secret: secret, wantErr: true},
+		{name: "wrong secret", hook: &fakeWebhook{}, secret: []byte("wrong"), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.hook.secret = secret
+			srv := httptest.NewServer(test.hook)
+			defer srv.Close()
+
+			w, err := newWebhookPublisher(srv.URL, test.secret)
+			if err != nil {
+				t.Fatalf("newWebhookPublisher: %v", err)
+			}
+			r := newRetryPublisher(w, retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond})
+			r.sleep = func(context.Context, time.Duration) error { return nil }
+			id, err := r.publish(context.Background(), &pubsub.Message{Data: []byte(`{"repo": "a/b"}`)})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("publish got err %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && id != "webhook-1" {
+				t.Errorf("publish got ID %q, want webhook-1", id)
+			}
+			if got := len(test.hook.bodies) == 1 && test.hook.bodies[0] == `{"repo": "a/b"}`; got != test.wantBody {
+				t.Errorf("webhook got bodies %q, want the message: %v", test.hook.bodies, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestNewWebhookPublisherErrors(t *testing.T) {
+	for _, u := range []string{"", "ftp://example.com", "https://"} {
+		if _, err := newWebhookPublisher(u, []byte("secret")); err == nil {
+			t.Errorf("newWebhookPublisher(%q) got nil error, want an error", u)
+		}
+	}
+	if _, err := newWebhookPublisher("https://example.com", nil); err == nil {
+		t.Errorf("newWebhookPublisher without a secret got nil error, want an error")
+	}
+}
+
+func TestReadWebhookSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if got, err := readWebhookSecret(path, "from-env"); err != nil || string(got) != "from-file" {
+		t.Errorf("readWebhookSecret(file) = %q, %v, want from-file", got, err)
+	}
+	if got, err := readWebhookSecret("", "from-env"); err != nil || string(got) != "from-env" {
+		t.Errorf("readWebhookSecret(env) = %q, %v, want from-env", got, err)
+	}
+}
+
+func TestNewTransportFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	p, err := newTransport(context.Background(), &config{transport: transportFile, outDir: dir})
+	if err != nil {
+		t.Fatalf("newTransport: %v", err)
+	}
+	if _, err := p.publish(context.Background(), &pubsub.Message{Data: []byte("{}")}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "message-1.json"))
+	if err != nil || strings.TrimSpace(string(data)) != "{}" {
+		t.Errorf("file transport wrote %q, %v, want {}", data, err)
+	}
+}
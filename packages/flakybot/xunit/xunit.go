@@ -0,0 +1,223 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xunit parses xUnit XML test reports the same way the Flaky Bot does.
+//
+// FindTestResults and FormatTestCase match findTestResults and formatTestCase
+// in the bot's src/flakybot.ts, so Go tools can tell which tests the bot
+// considers passed or failed, and which issue each one is reported in.
+package xunit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// TestSuites is the root element of a report with several test suites.
+type TestSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []*TestSuite `xml:"testsuite"`
+}
+
+// TestSuite is a group of test cases. The bot uses the suite name as the
+// package name of its tests.
+type TestSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr,omitempty"`
+	Cases    []*TestCase `xml:"testcase"`
+}
+
+// TestCase is a single test. At most one of Failure, Error, and Skipped
+// should be set. If none are, the test passed.
+type TestCase struct {
+	ClassName string  `xml:"classname,attr"`
+	Name      string  `xml:"name,attr"`
+	Time      string  `xml:"time,attr,omitempty"`
+	Failure   *Result `xml:"failure"`
+	Error     *Result `xml:"error"`
+	Skipped   *Result `xml:"skipped"`
+}
+
+// Result is the failure, error, or skip details of a TestCase.
+type Result struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// Test identifies a test the way the bot does.
+type Test struct {
+	Package string `json:"package,omitempty"`
+	Name    string `json:"testCase,omitempty"`
+	Passed  bool   `json:"passed"`
+}
+
+// Results are the tests found in a report. Skipped tests are in neither list.
+type Results struct {
+	Passes   []Test `json:"passes"`
+	Failures []Test `json:"failures"`
+}
+
+// Parse parses a report with either a testsuites or a testsuite root element.
+func Parse(data []byte) ([]*TestSuite, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "testsuites":
+			suites := &TestSuites{}
+			if err := d.DecodeElement(suites, &start); err != nil {
+				return nil, err
+			}
+			return suites.Suites, nil
+		case "testsuite":
+			// Python doesn't always have a top-level testsuites element.
+			suite := &TestSuite{}
+			if err := d.DecodeElement(suite, &start); err != nil {
+				return nil, err
+			}
+			return []*TestSuite{suite}, nil
+		default:
+			return nil, fmt.Errorf("unexpected root element %q, want testsuites or testsuite", start.Name.Local)
+		}
+	}
+}
+
+// FindTestResults returns the passed and failed tests in a report.
+//
+// Errors are treated as failures. Tests with the same FormatTestCase title
+// are only included once, keeping the last result for that title.
+func FindTestResults(data []byte) (*Results, error) {
+	suites, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	passes := &dedup{}
+	failures := &dedup{}
+	for _, suite := range suites {
+		for _, tc := range suite.Cases {
+			pkg := suite.Name
+			// Ruby doesn't always have a suite name, and pytest and Mocha use a
+			// fixed one.
+			if pkg == "" || pkg == "pytest" || pkg == "Mocha Tests" {
+				pkg = tc.ClassName
+			}
+			// Ignore skipped tests. They didn't pass and they didn't fail.
+			if tc.Skipped != nil {
+				continue
+			}
+			if tc.Failure == nil && tc.Error == nil {
+				passes.add(Test{Package: pkg, Name: tc.Name, Passed: true})
+				continue
+			}
+			failures.add(Test{Package: pkg, Name: tc.Name})
+		}
+	}
+	return &Results{Passes: passes.tests, Failures: failures.tests}, nil
+}
+
+// dedup keeps one test per FormatTestCase title, in the order the titles are
+// first seen.
+type dedup struct {
+	tests []Test
+	index map[string]int
+}
+
+func (d *dedup) add(t Test) {
+	if d.index == nil {
+		d.index = map[string]int{}
+	}
+	title := FormatTestCase(t)
+	if i, ok := d.index[title]; ok {
+		d.tests[i] = t
+		return
+	}
+	d.index[title] = len(d.tests)
+	d.tests = append(d.tests, t)
+}
+
+// EverythingFailedTitle is the issue title used when a test has no package or
+// name, meaning the whole build failed.
+const EverythingFailedTitle = "The build failed"
+
+// GroupedTestName is the test name used for the issue grouping many failures
+// in one package.
+const GroupedTestName = "many tests"
+
+var (
+	// pkgShorteners is a regex list where we should keep the matching group of
+	// the package.
+	pkgShorteners = []*regexp.Regexp{
+		regexp.MustCompile(`github\.com/[^/]+/[^/]+/(.+)`),
+		regexp.MustCompile(`com\.google\.cloud\.(.+)`),
+		regexp.MustCompile(`(.+)\(sponge_log\)`),
+		regexp.MustCompile(`cloud\.google\.com/go/(.+)`),
+	}
+	// nameShorteners is a regex list where we should keep the matching group
+	// of the test name.
+	nameShorteners = []*regexp.Regexp{
+		regexp.MustCompile(`([^/]+)/.+`), // Keep "group" of "group/of/tests".
+	}
+)
+
+// FormatTestCase returns the title of the issue the bot files for t.
+func FormatTestCase(t Test) string {
+	if t.Package == "" || t.Name == "" {
+		return EverythingFailedTitle
+	}
+	pkg := t.Package
+	for _, s := range pkgShorteners {
+		if m := s.FindStringSubmatch(pkg); m != nil {
+			pkg = m[1]
+		}
+	}
+	name := t.Name
+	for _, s := range nameShorteners {
+		if m := s.FindStringSubmatch(name); m != nil {
+			name = m[1]
+		}
+	}
+	return fmt.Sprintf("%s: %s failed", pkg, name)
+}
+
+// GroupedTest returns the test used for the issue grouping many failures in
+// pkg.
+func GroupedTest(pkg string) Test {
+	return Test{Package: pkg, Name: GroupedTestName}
+}
+
+// FormatGroupedTitle returns the title of the issue grouping many failures in
+// pkg.
+func FormatGroupedTitle(pkg string) string {
+	return FormatTestCase(GroupedTest(pkg))
+}
@@ -0,0 +1,199 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xunit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// testdata is shared with the bot's TypeScript tests.
+const testdata = "../test/fixtures/testdata"
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(testdata, name))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	return data
+}
+
+// These cases match the findTestResults tests in test/flakybot.test.ts.
+func TestFindTestResults(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *Results
+	}{
+		{
+			fixture: "one_failed.xml",
+			want: &Results{
+				Failures: []Test{
+					{Package: "github.com/GoogleCloudPlatform/golang-samples/spanner/spanner_snippets", Name: "TestSample"},
+				},
+				Passes: []Test{
+					{Package: "github.com/GoogleCloudPlatform/golang-samples", Name: "TestBadFiles", Passed: true},
+					{Package: "github.com/GoogleCloudPlatform/golang-samples", Name: "TestLicense", Passed: true},
+					{Package: "github.com/GoogleCloudPlatform/golang-samples", Name: "TestRegionTags", Passed: true},
+				},
+			},
+		},
+		{
+			fixture: "many_failed_same_pkg.xml",
+			want: &Results{
+				Failures: []Test{
+					{Package: "github.com/GoogleCloudPlatform/golang-samples/storage/buckets", Name: "TestBucketLock"},
+					{Package: "github.com/GoogleCloudPlatform/golang-samples/storage/buckets", Name: "TestUniformBucketLevelAccess"},
+				},
+				Passes: []Test{
+					{Package: "github.com/GoogleCloudPlatform/golang-samples", Name: "TestBadFiles", Passed: true},
+					{Package: "github.com/GoogleCloudPlatform/golang-samples/storage/buckets", Name: "TestCreate", Passed: true},
+					{Package: "github.com/GoogleCloudPlatform/golang-samples/storage/gcsupload", Name: "TestUpload", Passed: true},
+				},
+			},
+		},
+		{
+			fixture: "passed.xml",
+			want: &Results{
+				Passes: []Test{
+					{Package: "github.com/GoogleCloudPlatform/golang-samples", Name: "TestBadFiles", Passed: true},
+					{Package: "github.com/GoogleCloudPlatform/golang-samples/appengine/go11x/helloworld", Name: "TestIndexHandler", Passed: true},
+				},
+			},
+		},
+		{
+			fixture: "empty_results.xml",
+			want:    &Results{},
+		},
+		{
+			fixture: "go_skip.xml",
+			want: &Results{
+				Passes: []Test{
+					{Package: "github.com/GoogleCloudPlatform/golang-samples", Name: "TestLicense", Passed: true},
+				},
+			},
+		},
+		{
+			fixture: "python_one_error.xml",
+			want: &Results{
+				Failures: []Test{
+					{Package: "memorystore.redis.cloud_run_deployment.e2e_test", Name: "test_end_to_end"},
+				},
+			},
+		},
+		{
+			fixture: "python_one_passed.xml",
+			want: &Results{
+				Passes: []Test{
+					{Package: "appengine.standard.app_identity.asserting.main_test", Name: "test_app", Passed: true},
+				},
+			},
+		},
+		{
+			fixture: "node_one_failed.xml",
+			want: &Results{
+				Failures: []Test{
+					{Package: "Spanner", Name: "should delete and then insert rows in the example tables"},
+				},
+				Passes: []Test{
+					{Package: "Spanner", Name: "should create an example database", Passed: true},
+				},
+			},
+		},
+		{
+			fixture: "no_tests.xml",
+			want:    &Results{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			got, err := FindTestResults(readFixture(t, test.fixture))
+			if err != nil {
+				t.Fatalf("FindTestResults: %v", err)
+			}
+			if diff := cmp.Diff(got, test.want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("FindTestResults got diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFindTestResultsAllFixtures(t *testing.T) {
+	matches, err := filepath.Glob(filepath.Join(testdata, "*.xml"))
+	if err != nil {
+		t.Fatalf("filepath.Glob: %v", err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("no fixtures found in %s", testdata)
+	}
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			t.Fatalf("os.ReadFile: %v", err)
+		}
+		if _, err := FindTestResults(data); err != nil {
+			t.Errorf("FindTestResults(%s): %v", filepath.Base(m), err)
+		}
+	}
+}
+
+func TestFindTestResultsErrors(t *testing.T) {
+	for _, in := range []string{"", "not xml", "<project></project>"} {
+		if _, err := FindTestResults([]byte(in)); err == nil {
+			t.Errorf("FindTestResults(%q) got nil error, want an error", in)
+		}
+	}
+}
+
+func TestFormatTestCase(t *testing.T) {
+	tests := []struct {
+		in   Test
+		want string
+	}{
+		{
+			in:   Test{Package: "cloud.google.com/go/pubsub", Name: "TestPublish"},
+			want: "pubsub: TestPublish failed",
+		},
+		{
+			in:   Test{Package: "cloud.google.com/go/pubsub", Name: "TestPublish/One"},
+			want: "pubsub: TestPublish failed",
+		},
+		{
+			in:   Test{Package: "github.com/GoogleCloudPlatform/golang-samples/storage/buckets", Name: "TestCreate"},
+			want: "storage/buckets: TestCreate failed",
+		},
+		{
+			in:   Test{Package: "com.google.cloud.vision.it.ITSystemTest(sponge_log)", Name: "detectLabels"},
+			want: "vision.it.ITSystemTest: detectLabels failed",
+		},
+		{
+			in:   Test{},
+			want: EverythingFailedTitle,
+		},
+		{
+			in:   GroupedTest("cloud.google.com/go/pubsub"),
+			want: "pubsub: many tests failed",
+		},
+	}
+	for _, test := range tests {
+		if got := FormatTestCase(test.in); got != test.want {
+			t.Errorf("FormatTestCase(%+v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}